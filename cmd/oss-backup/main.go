@@ -2,12 +2,11 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"io/ioutil"
-	"log"
+	"io"
+	"os"
 	"oss-backup/internal/cmd"
 	"oss-backup/pkg/conf"
-	"oss-backup/pkg/utils"
+	"oss-backup/pkg/logger"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
@@ -18,7 +17,7 @@ var cfg conf.Config
 func main() {
 	dfFilename, err := homedir.Expand("~/.oss_backup.json")
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("locate default configure failed", "error", err)
 	}
 
 	root := &cobra.Command{Use: "oss-backup"}
@@ -26,43 +25,68 @@ func main() {
 	root.AddCommand(cmd.BackupCommand())
 	root.AddCommand(cmd.ListCommand())
 	root.AddCommand(cmd.DownloadCommand())
+	root.AddCommand(cmd.DiskUsageCommand())
+	root.AddCommand(cmd.DiffCommand())
+	root.AddCommand(cmd.CatCommand())
+	root.AddCommand(cmd.DaemonCommand())
+	root.AddCommand(cmd.UnlockCommand())
+	root.AddCommand(cmd.CopyCommand())
 
 	root.PersistentFlags().StringP("config", "c", dfFilename, "the configure to loading")
 	root.PersistentFlags().StringP("use", "u", "", "use the bucket as default")
-	root.PersistentPreRun = func(cmd *cobra.Command, _ []string) {
-		filename, _ := cmd.Flags().GetString("config")
+	root.PersistentFlags().StringP("log-level", "", "info", "level of logs, debug, info, warn or error")
+	root.PersistentFlags().StringP("log-format", "", logger.FormatText, "format of logs, text or json")
+	root.PersistentFlags().StringP("log-file", "", "", "append logs to the file instead of stderr")
+	root.PersistentPreRun = func(c *cobra.Command, _ []string) {
+		setupLogger(c)
+
+		filename, _ := c.Flags().GetString("config")
 		if filename == "" {
-			log.Fatal("please specify a configure file and continue")
+			cmd.FatalConfig("please specify a configure file and continue")
 		}
 
-		cfg.Filename = filename
-		if utils.Exists(filename) {
-			bs, err := ioutil.ReadFile(filename)
-			if err != nil {
-				log.Fatal(err)
-			}
+		if err := cfg.Load(filename); err != nil {
+			cmd.FatalConfig(err)
+		}
 
-			if len(bs) != 0 {
-				if err := json.Unmarshal(bs, &cfg); err != nil {
-					log.Fatal(err)
-				}
+		if name, _ := c.Flags().GetString("use"); name != "" {
+			if err := cfg.UseBucket(name); err != nil {
+				cmd.FatalConfig(err)
 			}
+		}
+	}
 
-			if name, _ := cmd.Flags().GetString("use"); name != "" {
-				if err := cfg.UseBucket(name); err != nil {
-					log.Fatal(err)
-				}
-			}
+	if err := root.ExecuteContext(context.WithValue(context.Background(), "cfg", &cfg)); err != nil {
+		cmd.Exit(cmd.ExitConfigError)
+	}
+	cmd.Exit(cmd.ExitOK)
+}
 
-			if cfg.GetBucket() == nil {
-				log.Fatal("unknown default bucket, please re-run and specify by -u")
-			}
-		} else {
-			if err := cfg.NewBucket(); err != nil {
-				log.Fatal(err)
-			}
+func setupLogger(c *cobra.Command) {
+	name, _ := c.Flags().GetString("log-level")
+	level, err := logger.ParseLevel(name)
+	if err != nil {
+		cmd.FatalConfig(err)
+	}
+
+	w := io.Writer(os.Stderr)
+	if filename, _ := c.Flags().GetString("log-file"); filename != "" {
+		fp, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			cmd.FatalConfig(err)
 		}
+		w = fp
 	}
 
-	_ = root.ExecuteContext(context.WithValue(context.Background(), "cfg", &cfg))
+	format, _ := c.Flags().GetString("log-format")
+	l, err := logger.New(w, level, format)
+	if err != nil {
+		cmd.FatalConfig(err)
+	}
+
+	// the logs are collected from many hosts
+	if host, err := os.Hostname(); err == nil {
+		l = l.With("host", host)
+	}
+	logger.SetDefault(l)
 }
@@ -3,11 +3,15 @@ module oss-backup
 go 1.14
 
 require (
-	github.com/aliyun/alibaba-cloud-sdk-go v1.61.641 // indirect
+	github.com/aliyun/alibaba-cloud-sdk-go v1.61.641
 	github.com/aliyun/aliyun-oss-go-sdk v2.1.4+incompatible
 	github.com/baiyubin/aliyun-sts-go-sdk v0.0.0-20180326062324-cfa1a18b161f // indirect
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/mitchellh/go-homedir v1.1.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/satori/go.uuid v1.2.0 // indirect
 	github.com/spf13/cobra v1.1.1
-	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e // indirect
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/time v0.0.0-20200630173020-3af7569d3a1e
 )
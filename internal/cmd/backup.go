@@ -2,21 +2,26 @@ package cmd
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"oss-backup/pkg/conf"
 	"oss-backup/pkg/crypto"
 	"oss-backup/pkg/limiter"
+	"oss-backup/pkg/lock"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/metrics"
+	"oss-backup/pkg/notify"
 	"oss-backup/pkg/storage"
 	"oss-backup/pkg/utils"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
-var uploader storage.Uploader
-
 func BackupCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "backup",
@@ -25,7 +30,21 @@ func BackupCommand() *cobra.Command {
 
 	cmd.PersistentFlags().StringP("prefix", "", "", "prefix of object key")
 	cmd.PersistentFlags().StringP("password", "", "", "password to encrypt filename")
+	cmd.PersistentFlags().StringP("host", "", "", "host recorded in objects, defaults to the hostname")
+	cmd.PersistentFlags().StringP("root", "", "", "derive object keys from paths relative to the root, requires key_mode hmac")
 	cmd.PersistentFlags().IntP("max-concurrency", "", 5, "number of max upload concurrency")
+	cmd.PersistentFlags().StringP("limit-upload", "", "", "max upload bandwidth of all workers, e.g. 10MiB/s")
+	cmd.PersistentFlags().BoolP("fail-fast", "", false, "abort all uploads once any of them failed")
+	cmd.PersistentFlags().BoolP("stdin", "", false, "upload the data read from stdin")
+	cmd.PersistentFlags().StringP("stdin-filename", "", "", "filename of the data read from stdin, e.g. db/prod.sql")
+	cmd.PersistentFlags().StringP("pre-hook", "", "", "command to run before backup, backup is aborted if it failed")
+	cmd.PersistentFlags().StringP("post-hook", "", "", "command to run after backup with OSS_BACKUP_* environment variables")
+	cmd.PersistentFlags().BoolP("watch", "w", false, "keep running and upload the changed files")
+	cmd.PersistentFlags().DurationP("watch-debounce", "", 2*time.Second, "quiet period before uploading a batch of changed files")
+	cmd.PersistentFlags().BoolP("check-content", "", false, "compare ctime, inode and checksum of files with the same size and mtime")
+	cmd.PersistentFlags().StringP("pack-threshold", "", "", "pack the files smaller than the size into pack objects, e.g. 64KiB")
+	cmd.PersistentFlags().StringP("pack-size", "", "32MiB", "target size of pack objects")
+	cmd.PersistentFlags().StringP("metrics-textfile", "", "", "write metrics to the file for textfile collector of node_exporter")
 	cmd.Run = doBackupCommand
 
 	return cmd
@@ -34,60 +53,333 @@ func BackupCommand() *cobra.Command {
 func doBackupCommand(cmd *cobra.Command, args []string) {
 	cfg := cmd.Context().Value("cfg").(*conf.Config)
 
-	password, _ := cmd.Flags().GetString("password")
+	job := &conf.Job{Name: "backup", Paths: args}
+	job.Prefix, _ = cmd.Flags().GetString("prefix")
+	job.Password, _ = cmd.Flags().GetString("password")
+	job.Host, _ = cmd.Flags().GetString("host")
+	job.Root, _ = cmd.Flags().GetString("root")
+	job.MaxConcurrency, _ = cmd.Flags().GetInt("max-concurrency")
+	job.LimitUpload, _ = cmd.Flags().GetString("limit-upload")
+	job.FailFast, _ = cmd.Flags().GetBool("fail-fast")
+	job.PreHook, _ = cmd.Flags().GetString("pre-hook")
+	job.PostHook, _ = cmd.Flags().GetString("post-hook")
+	job.CheckContent, _ = cmd.Flags().GetBool("check-content")
+	job.PackThreshold, _ = cmd.Flags().GetString("pack-threshold")
+	job.PackSize, _ = cmd.Flags().GetString("pack-size")
+
+	stdin, _ := cmd.Flags().GetBool("stdin")
+	stdinFilename, _ := cmd.Flags().GetString("stdin-filename")
+	if stdin && (stdinFilename == "" || len(args) != 0) {
+		FatalConfig("--stdin requires --stdin-filename and no paths")
+	}
+
+	watch, _ := cmd.Flags().GetBool("watch")
+	debounce, _ := cmd.Flags().GetDuration("watch-debounce")
+	if watch && (stdin || debounce <= 0) {
+		FatalConfig("--watch requires paths and a positive --watch-debounce")
+	}
+
+	b, err := newBackupJob(currentBucket(cfg), job)
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	if b.sinks, err = newNotifySinks(cfg); err != nil {
+		FatalConfig(err)
+	}
+
+	if stdin {
+		b.stdin, b.stdinFilename = os.Stdin, stdinFilename
+	}
+	b.gracefulInterrupt = true
+
+	if b.metricsTextfile, _ = cmd.Flags().GetString("metrics-textfile"); b.metricsTextfile != "" {
+		restoreLastSuccess(b.metricsTextfile)
+	}
+
+	if watch {
+		Exit(b.Watch(cmd.Context(), debounce))
+	}
+
+	sum := b.Run(cmd.Context())
+	Exit(sum.ExitCode())
+}
+
+// backupJob is the runtime of a backup configured by flags or daemon job
+type backupJob struct {
+	job      *conf.Job
+	host     string
+	prefix   string
+	uploader storage.Uploader
+	locker   storage.Uploader
+	aes      *crypto.Aes
+	bw       *limiter.BandwidthLimiter
+	log      *logger.Logger
+
+	stdin             io.Reader
+	stdinFilename     string
+	gracefulInterrupt bool
+	metricsTextfile   string
+	sinks             []*notify.Sink
+	packer            *packer
+	namer             *objectNamer
+}
+
+func newBackupJob(bucket *conf.Bucket, job *conf.Job) (*backupJob, error) {
+	password, err := job.GetPassword()
+	if err != nil {
+		return nil, err
+	}
+
 	if password == "" {
-		log.Fatal("password is required")
+		return nil, errors.New("password is required")
 	}
 
 	aes, err := crypto.NewAes(&crypto.AesConfig{Password: password})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	bucket := cfg.GetBucket()
-	prefix, _ := cmd.Flags().GetString("prefix")
-	bucket.ObjectPrefix = prefix
+	host, err := backupHost(job.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// the bucket may be shared by jobs with different prefix
+	cfg := *bucket
+	cfg.ObjectPrefix = hostPrefix(bucket, job.Prefix, host)
 
-	s, err := storage.NewAliYunOSS(bucket)
+	oss, err := storage.New(&cfg)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	uploader = s
 
-	maxConcurrency, _ := cmd.Flags().GetInt("max-concurrency")
-	cl := limiter.NewConcurrencyLimiter(maxConcurrency)
+	// locks are bucket-wide, so they are stored without the prefix
+	locker, err := storage.New(bucket)
+	if err != nil {
+		return nil, err
+	}
 
-	wg := sync.WaitGroup{}
-	for filename := range walk(args) {
-		wg.Add(1)
-		cl.Execute(func(args ...interface{}) {
-			upload(args[0].(string), aes)
-			wg.Done()
-		}, filename)
+	bytesPerSecond, err := utils.ParseRate(job.LimitUpload)
+	if err != nil {
+		return nil, err
 	}
 
-	wg.Wait()
+	packer, err := newPacker(job)
+	if err != nil {
+		return nil, err
+	}
+
+	namer, err := newObjectNamer(bucket, aes, job.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backupJob{
+		job:      job,
+		host:     host,
+		prefix:   cfg.ObjectPrefix,
+		uploader: storage.Instrument(oss, storageRequestsTotal, storageRequestDuration),
+		locker:   storage.Instrument(locker, storageRequestsTotal, storageRequestDuration),
+		aes:      aes,
+		bw:       limiter.NewBandwidthLimiter(bytesPerSecond),
+		log:      logger.With("job", job.Name, "host", host),
+		packer:   packer,
+		namer:    namer,
+	}, nil
 }
 
-func walk(paths []string) <-chan string {
+// Run uploads the files and returns the summary which is printed already
+func (b *backupJob) Run(ctx context.Context) *summary {
+	sum := newSummary("uploaded")
+	defer b.runPostHook(ctx, sum)
+	defer b.notify(sum)
+	defer b.record(sum)
+
+	if b.job.PreHook != "" {
+		if err := runHook(ctx, b.job.PreHook, []string{"OSS_BACKUP_HOOK=pre", "OSS_BACKUP_JOB=" + b.job.Name}); err != nil {
+			b.log.Error("pre-hook failed, backup aborted", "error", err)
+			sum.Abort()
+			return sum
+		}
+	}
+
+	b.transfer(ctx, sum, func(ctx context.Context, fail func(string, error)) <-chan string {
+		return walk(ctx, b.job.Paths, fail)
+	})
+
+	sum.Print(os.Stderr)
+	return sum
+}
+
+// transfer uploads the stdin stream if any and the files produced by source,
+// the files which source is unable to read are reported by fail.
+func (b *backupJob) transfer(ctx context.Context, sum *summary, source func(ctx context.Context, fail func(string, error)) <-chan string) {
+	ctx, tracker, stop := startProgress(ctx, "uploaded")
+	defer stop()
+
+	pool := limiter.NewPool(ctx, b.job.MaxConcurrency, b.job.FailFast)
+	if b.gracefulInterrupt {
+		defer handleInterrupt(pool)()
+	}
+
+	track := func(r result, size int64) error {
+		logResult(b.log, "uploaded", r)
+		tracker.Finish(size, r.Status == resultFailed)
+		sum.Add(r)
+		return r.Err
+	}
+
+	l, err := lock.Shared(ctx, b.locker)
+	if err != nil {
+		r := result{Filename: "(lock)", Status: resultFailed, Err: err}
+		logResult(b.log, "uploaded", r)
+		sum.Add(r)
+		return
+	}
+	defer func() {
+		if err := l.Release(); err != nil {
+			b.log.Warn("release lock failed", "error", err)
+		}
+	}()
+
+	if b.packer != nil {
+		if err := b.loadPackIndex(ctx); err != nil {
+			r := result{Filename: "(packs)", Status: resultFailed, Err: err}
+			logResult(b.log, "uploaded", r)
+			sum.Add(r)
+			return
+		}
+	}
+
+	sourceCtx, cancel := context.WithCancel(pool.Context())
+	defer cancel()
+
+	if b.stdin != nil {
+		sum.Scan()
+		tracker.Expect(-1)
+		_ = pool.Go(func(ctx context.Context) error {
+			start := time.Now()
+			r := b.uploadStream(ctx, b.stdinFilename, b.stdin)
+			r.Duration = time.Since(start)
+			return track(r, -1)
+		})
+	}
+
+	fail := func(filename string, err error) {
+		sum.Scan()
+		tracker.Expect(-1)
+		_ = track(result{Filename: filename, Status: resultFailed, Err: err}, -1)
+	}
+
+	for filename := range source(sourceCtx, fail) {
+		sum.Scan()
+
+		var size int64 = -1
+		if stat, err := os.Stat(filename); err == nil {
+			size = stat.Size()
+		}
+		tracker.Expect(size)
+
+		filename := filename
+		if b.packer.Accepts(size) {
+			if err := pool.Go(func(ctx context.Context) error {
+				return b.uploadPacked(ctx, filename, size, track)
+			}); err != nil {
+				sum.Interrupt()
+				break
+			}
+			continue
+		}
+
+		if err := pool.Go(func(ctx context.Context) error {
+			start := time.Now()
+			r := b.upload(ctx, filename)
+			r.Duration = time.Since(start)
+			return track(r, size)
+		}); err != nil {
+			sum.Interrupt()
+			break
+		}
+	}
+
+	cancel()
+	if b.packer != nil {
+		// the files of the last pack are uploaded unless aborted
+		pool.Drain()
+		_ = b.flushPack(pool.Context(), track)
+	}
+	_ = pool.Wait()
+}
+
+// record adds the summary to metrics, which are written to the textfile if any
+func (b *backupJob) record(sum *summary) {
+	sum.record(b.job.Name)
+	if b.metricsTextfile == "" {
+		return
+	}
+
+	if err := metrics.DefaultRegistry.WriteTextfile(b.metricsTextfile); err != nil {
+		b.log.Error("write metrics textfile failed", "file", b.metricsTextfile, "error", err)
+	}
+}
+
+func (b *backupJob) runPostHook(ctx context.Context, sum *summary) {
+	if b.job.PostHook == "" {
+		return
+	}
+
+	env := append(sum.Env(), "OSS_BACKUP_HOOK=post", "OSS_BACKUP_JOB="+b.job.Name)
+	if err := runHook(ctx, b.job.PostHook, env); err != nil {
+		b.log.Error("post-hook failed", "error", err)
+	}
+}
+
+// walk produces the files under paths, the paths which can not be read are
+// reported to fail if it's not nil.
+func walk(ctx context.Context, paths []string, fail func(filename string, err error)) <-chan string {
+	if fail == nil {
+		fail = func(string, error) {}
+	}
+
 	wg := sync.WaitGroup{}
 	files := make(chan string)
 	for _, path := range paths {
 		wg.Add(1)
 		go func(path string) {
 			defer wg.Done()
-			if ok, err := utils.IsDir(path); ok {
-				_ = filepath.Walk(path, func(filename string, info os.FileInfo, err error) error {
-					if err != nil || info.IsDir() {
-						return nil
-					}
+			ok, err := utils.IsDir(path)
+			if err != nil {
+				fail(path, err)
+				return
+			}
 
-					files <- filename
-					return nil
-				})
-			} else if err == nil {
-				files <- path
+			if !ok {
+				select {
+				case files <- path:
+				case <-ctx.Done():
+				}
+				return
 			}
+
+			_ = filepath.Walk(path, func(filename string, info os.FileInfo, err error) error {
+				if err != nil {
+					// the unreadable directory is skipped
+					fail(filename, err)
+					return nil
+				}
+
+				if info.IsDir() {
+					return nil
+				}
+
+				select {
+				case files <- filename:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
 		}(path)
 	}
 
@@ -99,37 +391,124 @@ func walk(paths []string) <-chan string {
 	return files
 }
 
-func upload(filename string, aes *crypto.Aes) {
+func (b *backupJob) upload(ctx context.Context, filename string) result {
+	r := result{Filename: filename, Status: resultFailed}
+	name, err := b.namer.Name(filename)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	key := b.namer.Key(name)
+	r.Key = key
+
 	stat, err := os.Stat(filename)
 	if err != nil {
-		log.Printf("unable to stat file %s", filename)
-		return
+		r.Err = fmt.Errorf("unable to stat file %s: %w", filename, err)
+		return r
 	}
 
-	key := utils.Md5(filename)
-	if uploader.Exists(key) {
-		log.Printf("object exists for file %s(%s)", filename, key)
+	exists, err := b.uploader.Exists(ctx, key)
+	if err != nil {
+		r.Err = fmt.Errorf("check object of file %s: %w", filename, err)
+		return r
+	}
 
-		md := uploader.Metadata(key)
-		if md.ModTime() == stat.ModTime().Unix() {
-			log.Printf("file not modify %s(%s), SKIP", filename, key)
-			return
+	var checksum string
+	if exists {
+		md, err := b.uploader.Metadata(ctx, key)
+		if err != nil {
+			r.Err = fmt.Errorf("metadata of file %s: %w", filename, err)
+			return r
+		}
+
+		var unchanged bool
+		if unchanged, checksum, err = b.unchanged(filename, stat, md); err != nil {
+			r.Err = fmt.Errorf("compare file %s: %w", filename, err)
+			return r
+		}
+
+		if unchanged {
+			// the changed ctime or inode is recorded to avoid hashing again
+			if checksum != "" {
+				md := b.fileMetadata(stat, checksum)
+				md.SetFilename(b.aes.EncryptToBase64([]byte(name)))
+				if err := b.uploader.SetMetadata(ctx, key, md); err != nil {
+					r.Err = fmt.Errorf("update metadata of file %s: %w", filename, err)
+					return r
+				}
+			}
+
+			r.Status = resultSkipped
+			return r
 		}
 	}
 
-	md := make(storage.Metadata)
-	md.SetModTime(stat.ModTime().Unix())
-	md.SetFilename(aes.EncryptToBase64([]byte(filename)))
-	md.SetFileSize(int(stat.Size()))
+	if b.job.CheckContent && checksum == "" {
+		if checksum, err = fileChecksum(filename); err != nil {
+			r.Err = fmt.Errorf("checksum of file %s: %w", filename, err)
+			return r
+		}
+	}
+
+	md := b.fileMetadata(stat, checksum)
+	md.SetFilename(b.aes.EncryptToBase64([]byte(name)))
 
 	fp, err := os.Open(filename)
 	if err != nil {
-		log.Printf("cannot open file %s", err)
-		return
+		r.Err = fmt.Errorf("cannot open file %s: %w", filename, err)
+		return r
 	}
+	defer func() { _ = fp.Close() }()
 
 	item := &storage.Item{Filename: filename, ObjectKey: key, FileSize: stat.Size()}
-	if err := uploader.Upload(context.Background(), item, aes.ProxyReader(fp), md); err != nil {
-		log.Printf("upload file %s failed, cause by %s", filename, err)
+	data := storage.RewindReader(fp, func(r io.Reader) io.Reader {
+		return b.bw.Reader(ctx, b.aes.ProxyReader(r))
+	})
+	if err := b.uploader.Upload(ctx, item, data, md); err != nil {
+		r.Err = fmt.Errorf("upload file %s failed, cause by %w", filename, err)
+		return r
+	}
+
+	r.Status, r.Bytes = resultTransferred, stat.Size()
+	return r
+}
+
+type countingReader struct {
+	source io.Reader
+	n      int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// uploadStream uploads the data of unknown size with a virtual filename, the
+// size in metadata is updated after the stream drained.
+func (b *backupJob) uploadStream(ctx context.Context, filename string, source io.Reader) result {
+	key := b.namer.Key(filename)
+	r := result{Filename: filename, Key: key, Status: resultFailed}
+
+	md := make(storage.Metadata)
+	md.SetModTime(time.Now().Unix())
+	md.SetFilename(b.aes.EncryptToBase64([]byte(filename)))
+	md.SetHost(b.aes.EncryptToBase64([]byte(b.host)))
+
+	counter := &countingReader{source: source}
+	item := &storage.Item{Filename: filename, ObjectKey: key, FileSize: -1}
+	if err := b.uploader.Upload(ctx, item, b.bw.Reader(ctx, b.aes.ProxyReader(counter)), md); err != nil {
+		r.Err = fmt.Errorf("upload stream %s failed, cause by %w", filename, err)
+		return r
+	}
+
+	md.SetFileSize(int(counter.n))
+	if err := b.uploader.SetMetadata(ctx, key, md); err != nil {
+		r.Err = fmt.Errorf("update metadata of %s failed, cause by %w", filename, err)
+		return r
 	}
+
+	r.Status, r.Bytes = resultTransferred, counter.n
+	return r
 }
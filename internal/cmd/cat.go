@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/crypto"
+	"oss-backup/pkg/limiter"
+	"oss-backup/pkg/lock"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/storage"
+	"oss-backup/pkg/utils"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+func CatCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cat <path-or-key>",
+		Short: "print decrypted content of the object to stdout",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	cmd.PersistentFlags().StringP("prefix", "", "", "prefix of object key used by backup")
+	cmd.PersistentFlags().StringP("password", "", "", "password to encrypt filename")
+	cmd.PersistentFlags().StringP("host", "", "", "host of backup, defaults to the hostname")
+	cmd.PersistentFlags().StringP("root", "", "", "root used by backup to derive object keys")
+	cmd.PersistentFlags().StringP("limit-download", "", "", "max download bandwidth, e.g. 10MiB/s")
+	cmd.Run = doCatCommand
+
+	return cmd
+}
+
+func doCatCommand(cmd *cobra.Command, args []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	password, _ := cmd.Flags().GetString("password")
+	if password == "" {
+		FatalConfig("password is required")
+	}
+
+	aes, err := crypto.NewAes(&crypto.AesConfig{Password: password})
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	oss, err := storage.New(currentBucket(cfg))
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	root, _ := cmd.Flags().GetString("root")
+	namer, err := newObjectNamer(currentBucket(cfg), aes, root)
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	limit, _ := cmd.Flags().GetString("limit-download")
+	bytesPerSecond, err := utils.ParseRate(limit)
+	if err != nil {
+		FatalConfig(err)
+	}
+	bw := limiter.NewBandwidthLimiter(bytesPerSecond)
+
+	host, _ := cmd.Flags().GetString("host")
+	if host, err = backupHost(host); err != nil {
+		FatalConfig(err)
+	}
+
+	prefix, _ := cmd.Flags().GetString("prefix")
+	prefix = hostPrefix(currentBucket(cfg), prefix, host)
+
+	ctx := cmd.Context()
+	l, err := lock.Shared(ctx, oss)
+	if err != nil {
+		logger.Error("lock repository failed", "error", err)
+		Exit(ExitTotalFailure)
+	}
+	release := func() {
+		if err := l.Release(); err != nil {
+			logger.Warn("release lock failed", "error", err)
+		}
+	}
+
+	item, err := resolveObject(ctx, oss, aes, namer, prefix, args[0])
+	if err != nil {
+		logger.Error("resolve object failed", "file", args[0], "error", err)
+		release()
+		Exit(ExitTotalFailure)
+	}
+
+	ignoreBrokenPipe()
+	if err := oss.Download(ctx, item, bw.Writer(ctx, aes.ProxyWriter(os.Stdout))); err != nil {
+		release()
+
+		// the reader of output is gone, e.g. piped to head
+		if errors.Is(err, syscall.EPIPE) {
+			Exit(ExitOK)
+		}
+		logger.Error("download failed", "file", item.Filename, "key", item.ObjectKey, "error", err)
+		Exit(ExitTotalFailure)
+	}
+	release()
+}
+
+// resolveObject finds the object by its key, the key derived from filename,
+// or the decrypted filename of all objects (the newest one wins).
+func resolveObject(ctx context.Context, uploader storage.Uploader, aes *crypto.Aes, namer *objectNamer, prefix, name string) (*storage.Item, error) {
+	keys, filenames := []string{name}, []string{utils.NormalizePath(name)}
+	if recorded, err := namer.Name(name); err == nil {
+		keys = append(keys, storage.ObjectKey(prefix, namer.Key(recorded)))
+		filenames = append(filenames, utils.NormalizePath(recorded))
+	}
+
+	for _, key := range keys {
+		exists, err := uploader.Exists(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if exists {
+			md, err := uploader.Metadata(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+
+			return &storage.Item{
+				Filename:  string(aes.DecryptFromBase64(md.Filename())),
+				ObjectKey: key,
+				FileSize:  int64(md.FileSize()),
+				Metadata:  md,
+			}, nil
+		}
+	}
+
+	var found *storage.Item
+	items, wait := listObjects(ctx, uploader, aes, []string{prefix}, nil)
+	for item := range items {
+		filename := utils.NormalizePath(item.Filename)
+		for _, name := range filenames {
+			if filename == name && (found == nil || item.ModTime.After(found.ModTime)) {
+				found = item
+			}
+		}
+	}
+
+	if err := wait(); err != nil {
+		return nil, err
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("no object found for %s", name)
+	}
+	return found, nil
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"oss-backup/pkg/crypto"
+	"oss-backup/pkg/storage"
+	"oss-backup/pkg/utils"
+)
+
+// fileMetadata returns the metadata of file to upload except the filename,
+// the checksum is only recorded if it's computed.
+func (b *backupJob) fileMetadata(stat os.FileInfo, checksum string) storage.Metadata {
+	md := make(storage.Metadata)
+	md.SetModTime(stat.ModTime().Unix())
+	md.SetModTimeNs(stat.ModTime().UnixNano())
+	md.SetChangeTime(utils.ChangeTime(stat))
+	md.SetFileSize(int(stat.Size()))
+	md.SetHost(b.aes.EncryptToBase64([]byte(b.host)))
+	if checksum != "" {
+		md.SetChecksum(b.aes.EncryptToBase64([]byte(checksum)))
+	}
+	return md
+}
+
+// fileChecksum returns the sha256 of file in hex
+func fileChecksum(filename string) (string, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = fp.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fp); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sameModTime compares the mtime in nanoseconds if it's recorded
+func sameModTime(md storage.Metadata, stat os.FileInfo) bool {
+	if ns := md.ModTimeNs(); ns != 0 {
+		return ns == stat.ModTime().UnixNano()
+	}
+	return md.ModTime() == stat.ModTime().Unix()
+}
+
+// contentChanged compares the file with the object of same size and mtime,
+// the ctime and inode are trusted if they are unchanged, otherwise (e.g. the
+// mtime is preserved by rsync) the checksum is compared. The checksum of file
+// is returned if it's computed.
+func contentChanged(aes *crypto.Aes, filename string, stat os.FileInfo, md storage.Metadata) (bool, string, error) {
+	ctime, inode := md.ChangeTime()
+	if localCtime, localInode := utils.ChangeTime(stat); ctime != 0 && ctime == localCtime && inode == localInode {
+		return false, "", nil
+	}
+
+	checksum, err := fileChecksum(filename)
+	if err != nil {
+		return false, "", err
+	}
+
+	remote := md.Checksum()
+	return remote == "" || string(aes.DecryptFromBase64(remote)) != checksum, checksum, nil
+}
+
+// unchanged reports whether the file is backed up as the object of md, only
+// the mtime in seconds is compared unless check_content is enabled. The
+// checksum of file is returned if it's computed.
+func (b *backupJob) unchanged(filename string, stat os.FileInfo, md storage.Metadata) (bool, string, error) {
+	if !b.job.CheckContent {
+		return md.ModTime() == stat.ModTime().Unix(), "", nil
+	}
+
+	if int64(md.FileSize()) != stat.Size() || !sameModTime(md, stat) {
+		return false, "", nil
+	}
+
+	changed, checksum, err := contentChanged(b.aes, filename, stat, md)
+	return !changed, checksum, err
+}
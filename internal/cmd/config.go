@@ -2,9 +2,12 @@ package cmd
 
 import (
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"oss-backup/pkg/conf"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/utils"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -21,18 +24,246 @@ func ConfigCommand() *cobra.Command {
 	cmd.PersistentFlags().BoolP("delete", "d", false, "delete bucket")
 	cmd.Run = doConfigCommand
 
+	cmd.AddCommand(configAddCommand())
+	cmd.AddCommand(configSetCommand())
+	cmd.AddCommand(configRenameCommand())
+	cmd.AddCommand(configImportCommand())
+	cmd.AddCommand(configExportCommand())
+
+	return cmd
+}
+
+func configAddCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "add a bucket without prompting",
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.Flags().StringP("alias", "", "", "alias of the bucket")
+	cmd.Flags().StringP("endpoint", "", "", "endpoint of the bucket")
+	cmd.Flags().StringP("bucket", "", "", "name of the bucket")
+	cmd.Flags().StringP("access-key-id", "", "", "access key id")
+	cmd.Flags().StringP("access-key-secret-file", "", "", "file contains access key secret, - for stdin")
+	cmd.Flags().StringP("credentials-file", "", "", "json file contains credentials maintained by others")
+	cmd.Flags().StringP("role-arn", "", "", "arn of the role to assume by STS")
+	cmd.Flags().StringP("ecs-ram-role", "", "", "name of RAM role attached to the ECS instance")
+	cmd.Flags().StringP("type", "", "", "type of the storage, oss or s3 for the services compatible with S3, e.g. MinIO")
+	cmd.Flags().StringP("region", "", "", "region of the s3 bucket, us-east-1 by default")
+	cmd.Flags().StringP("key-mode", "", "", "how object keys are derived from filenames, md5 by default or hmac")
+	cmd.Flags().BoolP("host-namespace", "", true, "put the objects of each host under the directory of host")
+	cmd.Flags().StringP("retry-attempts", "", "", "attempts of the transient errors, 5 by default")
+	cmd.Flags().StringP("retry-backoff", "", "", "backoff before the first retry, 1s by default")
+	cmd.Flags().StringP("retry-max-backoff", "", "", "max backoff between retries, 30s by default")
+	cmd.Flags().StringP("retry-jitter", "", "", "fraction of backoff randomized, 0.2 by default")
+	cmd.Flags().BoolP("default", "", false, "use the bucket as default")
+	cmd.Run = doConfigAddCommand
+
+	return cmd
+}
+
+func doConfigAddCommand(cmd *cobra.Command, _ []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	bucket := &conf.Bucket{}
+	bucket.Alias, _ = cmd.Flags().GetString("alias")
+	bucket.Endpoint, _ = cmd.Flags().GetString("endpoint")
+	bucket.BucketName, _ = cmd.Flags().GetString("bucket")
+	bucket.AccessKeyId, _ = cmd.Flags().GetString("access-key-id")
+	bucket.CredentialsFile, _ = cmd.Flags().GetString("credentials-file")
+	bucket.RoleArn, _ = cmd.Flags().GetString("role-arn")
+	bucket.EcsRamRole, _ = cmd.Flags().GetString("ecs-ram-role")
+	bucket.Type, _ = cmd.Flags().GetString("type")
+	bucket.Region, _ = cmd.Flags().GetString("region")
+	bucket.KeyMode, _ = cmd.Flags().GetString("key-mode")
+	bucket.HostNamespace, _ = cmd.Flags().GetBool("host-namespace")
+	if filename, _ := cmd.Flags().GetString("access-key-secret-file"); filename != "" {
+		if err := bucket.Set("access_key_secret_file", filename); err != nil {
+			logger.Fatal("read access key secret failed", "file", filename, "error", err)
+		}
+	}
+	for _, key := range []string{"retry_attempts", "retry_backoff", "retry_max_backoff", "retry_jitter"} {
+		if value, _ := cmd.Flags().GetString(strings.ReplaceAll(key, "_", "-")); value != "" {
+			if err := bucket.Set(key, value); err != nil {
+				logger.Fatal("set field failed", "bucket", bucket.Alias, "key", key, "error", err)
+			}
+		}
+	}
+
+	if err := cfg.AddBucket(bucket); err != nil {
+		logger.Fatal("add bucket failed", "bucket", bucket.Alias, "error", err)
+	}
+
+	if asDefault, _ := cmd.Flags().GetBool("default"); asDefault {
+		cfg.DefaultBucket = bucket.Alias
+	}
+
+	if err := cfg.Save(); err != nil {
+		logger.Fatal("save config failed", "file", cfg.Filename, "error", err)
+	}
+	fmt.Printf("Add %s\n", bucket.Alias)
+}
+
+func configSetCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set <alias> key=value...",
+		Short: "update fields of the bucket",
+		Args:  cobra.MinimumNArgs(2),
+	}
+
+	cmd.Run = doConfigSetCommand
+
+	return cmd
+}
+
+func doConfigSetCommand(cmd *cobra.Command, args []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	bucket := cfg.FindBucket(args[0])
+	if bucket == nil {
+		logger.Fatal("unknown bucket", "bucket", args[0])
+	}
+
+	for _, kv := range args[1:] {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			logger.Fatal("invalid assignment, expected key=value", "assignment", kv)
+		}
+
+		if err := bucket.Set(parts[0], parts[1]); err != nil {
+			logger.Fatal("set field failed", "bucket", args[0], "key", parts[0], "error", err)
+		}
+	}
+
+	if err := bucket.Validate(); err != nil {
+		logger.Fatal("invalid bucket", "bucket", args[0], "error", err)
+	}
+
+	if err := cfg.Save(); err != nil {
+		logger.Fatal("save config failed", "file", cfg.Filename, "error", err)
+	}
+}
+
+func configRenameCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rename <alias> <new-alias>",
+		Short: "rename alias of the bucket",
+		Args:  cobra.ExactArgs(2),
+	}
+
+	cmd.Run = doConfigRenameCommand
+
+	return cmd
+}
+
+func doConfigRenameCommand(cmd *cobra.Command, args []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	if err := cfg.RenameBucket(args[0], args[1]); err != nil {
+		logger.Fatal("rename bucket failed", "bucket", args[0], "error", err)
+	}
+
+	if err := cfg.Save(); err != nil {
+		logger.Fatal("save config failed", "file", cfg.Filename, "error", err)
+	}
+	fmt.Printf("Rename %s to %s\n", args[0], args[1])
+}
+
+func configImportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import [file]",
+		Short: "import buckets from exported file or stdin",
+		Args:  cobra.MaximumNArgs(1),
+	}
+
+	cmd.Flags().BoolP("overwrite", "", false, "overwrite the bucket with same alias")
+	cmd.Run = doConfigImportCommand
+
+	return cmd
+}
+
+func doConfigImportCommand(cmd *cobra.Command, args []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	r := io.Reader(os.Stdin)
+	if len(args) != 0 && args[0] != "-" {
+		fp, err := os.Open(args[0])
+		if err != nil {
+			logger.Fatal("open import file failed", "file", args[0], "error", err)
+		}
+		defer func() { _ = fp.Close() }()
+		r = fp
+	}
+
+	overwrite, _ := cmd.Flags().GetBool("overwrite")
+	n, err := cfg.Import(r, overwrite)
+	if err != nil {
+		logger.Fatal("import buckets failed", "error", err)
+	}
+
+	if err := cfg.Save(); err != nil {
+		logger.Fatal("save config failed", "file", cfg.Filename, "error", err)
+	}
+	fmt.Printf("Import %d bucket(s)\n", n)
+}
+
+func configExportCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export [alias...]",
+		Short: "export buckets to file or stdout",
+	}
+
+	cmd.Flags().StringP("output", "o", "", "file to write, default to stdout")
+	cmd.Run = doConfigExportCommand
+
 	return cmd
 }
 
+func doConfigExportCommand(cmd *cobra.Command, args []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	w := io.Writer(os.Stdout)
+	if output, _ := cmd.Flags().GetString("output"); output != "" && output != "-" {
+		fp, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			logger.Fatal("open export file failed", "file", output, "error", err)
+		}
+		defer func() { _ = fp.Close() }()
+		w = fp
+	}
+
+	if err := cfg.Export(w, args...); err != nil {
+		logger.Fatal("export buckets failed", "error", err)
+	}
+}
+
+// currentBucket returns the bucket selected by -u or the default one, the
+// interactive wizard is only used when nothing configured and stdin is a
+// terminal.
+func currentBucket(cfg *conf.Config) *conf.Bucket {
+	if bucket := cfg.GetBucket(); bucket != nil {
+		return bucket
+	}
+
+	if !utils.IsTerminal(os.Stdin) {
+		FatalConfig("no bucket configured, please add one by `oss-backup config add`")
+	}
+
+	if err := cfg.NewBucket(); err != nil {
+		FatalConfig(err)
+	}
+	return cfg.GetBucket()
+}
+
 func doConfigCommand(cmd *cobra.Command, args []string) {
 	cfg := cmd.Context().Value("cfg").(*conf.Config)
 
 	if create, _ := cmd.Flags().GetBool("new"); create {
 		if err := cfg.NewBucket(); err != nil {
-			log.Fatal(err)
+			logger.Fatal("create bucket failed", "error", err)
 		}
 
-		os.Exit(0)
+		Exit(ExitOK)
 	}
 
 	if dump, _ := cmd.Flags().GetBool("dump-pem"); dump {
@@ -45,16 +276,16 @@ func doConfigCommand(cmd *cobra.Command, args []string) {
 			if bucket := cfg.FindBucket(name); bucket != nil {
 				fp, err := os.Create(bucket.BucketName + ".pem")
 				if err != nil {
-					log.Fatal(err)
+					logger.Fatal("create pem file failed", "bucket", name, "error", err)
 				}
 
 				if err := bucket.DumpRsaPrivateKey(fp); err != nil {
-					log.Fatal(err)
+					logger.Fatal("dump rsa private key failed", "bucket", name, "error", err)
 				}
 			}
 		}
 
-		os.Exit(0)
+		Exit(ExitOK)
 	}
 
 	if remove, _ := cmd.Flags().GetBool("delete"); remove {
@@ -64,10 +295,10 @@ func doConfigCommand(cmd *cobra.Command, args []string) {
 		}
 
 		if err := cfg.Save(); err != nil {
-			log.Fatal(err)
+			logger.Fatal("save config failed", "file", cfg.Filename, "error", err)
 		}
 
-		os.Exit(0)
+		Exit(ExitOK)
 	}
 
 	buckets := conf.Buckets{cfg.GetBucket()}
@@ -90,6 +321,21 @@ func doConfigCommand(cmd *cobra.Command, args []string) {
 		fmt.Printf("Endpoint: %s\n", bucket.Endpoint)
 		fmt.Printf("AccessKeyId: %s\n", bucket.AccessKeyId)
 		fmt.Printf("AccessKeySecret: %s\n", bucket.AccessKeySecret)
+		for _, field := range []struct{ name, value string }{
+			{"CredentialsFile", bucket.CredentialsFile},
+			{"RoleArn", bucket.RoleArn},
+			{"RoleSessionName", bucket.RoleSessionName},
+			{"StsEndpoint", bucket.StsEndpoint},
+			{"EcsRamRole", bucket.EcsRamRole},
+			{"KeyMode", bucket.KeyMode},
+		} {
+			if field.value != "" {
+				fmt.Printf("%s: %s\n", field.name, field.value)
+			}
+		}
+		if bucket.HostNamespace {
+			fmt.Println("HostNamespace: true")
+		}
 
 		if len(buckets) != 1 && i != len(buckets)-1 {
 			fmt.Println()
@@ -0,0 +1,400 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/crypto"
+	"oss-backup/pkg/limiter"
+	"oss-backup/pkg/lock"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/storage"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func CopyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "copy [prefix...]",
+		Short: "copy objects to another bucket",
+	}
+
+	cmd.PersistentFlags().StringP("from", "", "", "alias of the source bucket, defaults to the current bucket")
+	cmd.PersistentFlags().StringP("to", "", "", "alias of the destination bucket")
+	cmd.PersistentFlags().StringP("password", "", "", "password to encrypt filename")
+	cmd.PersistentFlags().StringP("to-password", "", "", "password of the destination, objects are re-encrypted if it's different")
+	cmd.PersistentFlags().IntP("max-concurrency", "", 5, "number of max copy concurrency")
+	cmd.PersistentFlags().BoolP("fail-fast", "", false, "abort all copies once any of them failed")
+	cmd.Run = doCopyCommand
+
+	return cmd
+}
+
+func doCopyCommand(cmd *cobra.Command, args []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	from, to := currentBucket(cfg), (*conf.Bucket)(nil)
+	if name, _ := cmd.Flags().GetString("from"); name != "" {
+		if from = cfg.FindBucket(name); from == nil {
+			FatalConfig("bucket not found: ", name)
+		}
+	}
+
+	name, _ := cmd.Flags().GetString("to")
+	if name == "" {
+		FatalConfig("--to is required")
+	}
+	if to = cfg.FindBucket(name); to == nil {
+		FatalConfig("bucket not found: ", name)
+	}
+	if from.Endpoint == to.Endpoint && from.BucketName == to.BucketName {
+		FatalConfig("source and destination are the same bucket")
+	}
+
+	password, _ := cmd.Flags().GetString("password")
+	if password == "" {
+		FatalConfig("password is required")
+	}
+
+	toPassword, _ := cmd.Flags().GetString("to-password")
+	if toPassword == "" {
+		toPassword = password
+	}
+
+	c, err := newCopier(from, to, password, toPassword)
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	if len(args) == 0 {
+		args = []string{""}
+	}
+
+	var locks []*lock.Lock
+	release := func() {
+		for _, l := range locks {
+			if err := l.Release(); err != nil {
+				logger.Warn("release lock failed", "error", err)
+			}
+		}
+	}
+
+	// both of the buckets are locked as they are read and written
+	for _, uploader := range []storage.Uploader{c.src, c.dst} {
+		l, err := lock.Shared(cmd.Context(), uploader)
+		if err != nil {
+			logger.Error("lock repository failed", "error", err)
+			release()
+			Exit(ExitTotalFailure)
+		}
+		locks = append(locks, l)
+	}
+
+	if err := c.loadExisting(cmd.Context(), args); err != nil {
+		logger.Error("list objects of destination failed", "error", err)
+		release()
+		Exit(ExitTotalFailure)
+	}
+
+	maxConcurrency, _ := cmd.Flags().GetInt("max-concurrency")
+	failFast, _ := cmd.Flags().GetBool("fail-fast")
+	ctx, tracker, stopProgress := startProgress(cmd.Context(), "copied")
+	pool := limiter.NewPool(ctx, maxConcurrency, failFast)
+	stop := handleInterrupt(pool)
+
+	sum := newSummary("copied")
+	for _, prefix := range args {
+		items, wait := c.src.ListObject(ctx, prefix)
+		drained := true
+		for item := range items {
+			if item.Metadata.Kind() == lock.Kind {
+				continue
+			}
+
+			sum.Scan()
+			tracker.Expect(item.FileSize)
+
+			item := item
+			if err := pool.Go(func(ctx context.Context) error {
+				start := time.Now()
+				r := c.Copy(ctx, item)
+				r.Duration = time.Since(start)
+				logResult(logger.Default(), "copied", r)
+
+				tracker.Finish(item.FileSize, r.Status == resultFailed)
+				sum.Add(r)
+				return r.Err
+			}); err != nil {
+				sum.Interrupt()
+				drained = false
+				break
+			}
+		}
+
+		// the listing is still running if interrupted
+		if !drained {
+			break
+		}
+		if err := wait(); err != nil {
+			logger.Error("list objects failed", "error", err)
+			sum.Add(result{Filename: "(listing)", Status: resultFailed, Err: err})
+		}
+	}
+
+	_ = pool.Wait()
+	stop()
+	stopProgress()
+
+	release()
+
+	sum.Print(os.Stderr)
+	Exit(sum.ExitCode())
+}
+
+// copier copies the objects between buckets, the objects are re-encrypted
+// if the passwords are different, and the keys are derived again if they
+// depend on the password or key_mode.
+type copier struct {
+	src, dst           storage.Uploader
+	srcAes, dstAes     *crypto.Aes
+	srcNamer, dstNamer *objectNamer
+	reencrypt          bool
+	rekey              bool
+
+	// existing is the metadata of objects in destination by key
+	existing map[string]storage.Metadata
+}
+
+func newCopier(from, to *conf.Bucket, password, toPassword string) (*copier, error) {
+	c := &copier{reencrypt: password != toPassword}
+
+	var err error
+	if c.srcAes, err = crypto.NewAes(&crypto.AesConfig{Password: password}); err != nil {
+		return nil, err
+	}
+	if c.dstAes, err = crypto.NewAes(&crypto.AesConfig{Password: toPassword}); err != nil {
+		return nil, err
+	}
+
+	if c.srcNamer, err = newObjectNamer(from, c.srcAes, ""); err != nil {
+		return nil, err
+	}
+	if c.dstNamer, err = newObjectNamer(to, c.dstAes, ""); err != nil {
+		return nil, err
+	}
+	c.rekey = c.srcNamer.hmac != c.dstNamer.hmac || (c.reencrypt && c.dstNamer.hmac)
+
+	src, err := storage.New(from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := storage.New(to)
+	if err != nil {
+		return nil, err
+	}
+
+	c.src = storage.Instrument(src, storageRequestsTotal, storageRequestDuration)
+	c.dst = storage.Instrument(dst, storageRequestsTotal, storageRequestDuration)
+	return c, nil
+}
+
+// loadExisting lists the objects of destination under the prefixes
+func (c *copier) loadExisting(ctx context.Context, prefixes []string) error {
+	c.existing = make(map[string]storage.Metadata)
+	for _, prefix := range prefixes {
+		items, wait := c.dst.ListObject(ctx, prefix)
+		for item := range items {
+			c.existing[item.ObjectKey] = item.Metadata
+		}
+
+		if err := wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Key returns the key and recorded filename in destination of the object,
+// the prefix of key is kept. The filename of md5 key is made absolute for
+// hmac key as the backup to destination would, the other filenames are kept,
+// e.g. the paths relative to root of hmac keys.
+func (c *copier) Key(key, name string) (string, string, error) {
+	if !c.rekey {
+		return key, name, nil
+	}
+
+	dstName := name
+	if !c.srcNamer.hmac && c.dstNamer.hmac {
+		var err error
+		if dstName, err = c.dstNamer.Name(name); err != nil {
+			return "", "", err
+		}
+	}
+
+	if old := c.srcNamer.Key(name); strings.HasSuffix(key, old) {
+		return strings.TrimSuffix(key, old) + c.dstNamer.Key(dstName), dstName, nil
+	}
+	return key, name, nil
+}
+
+// encrypt re-encrypts the encrypted value of metadata
+func (c *copier) encrypt(v string) string {
+	return c.dstAes.EncryptToBase64(c.srcAes.DecryptFromBase64(v))
+}
+
+// Copy copies the object, which is skipped if it exists in destination with
+// the same mtime and size.
+func (c *copier) Copy(ctx context.Context, item *storage.Item) result {
+	if item.Metadata.Kind() == storage.KindPack {
+		return c.copyPack(ctx, item)
+	}
+
+	item.Filename = string(c.srcAes.DecryptFromBase64(item.Metadata.Filename()))
+	key, name, err := c.Key(item.ObjectKey, item.Filename)
+	r := result{Filename: item.Filename, Key: key, Status: resultFailed, Err: err}
+	if err != nil {
+		return r
+	}
+	if md, ok := c.existing[key]; ok && md.ModTime() == item.Metadata.ModTime() && md.FileSize() == item.Metadata.FileSize() {
+		r.Status = resultSkipped
+		return r
+	}
+
+	md := item.Metadata.User()
+	download, upload := nopWriter, nopReader
+	if c.reencrypt {
+		md.ReplaceEncrypted(c.encrypt)
+		download, upload = c.srcAes.ProxyWriter, c.dstAes.ProxyReader
+	}
+	if name != item.Filename {
+		md.SetFilename(c.dstAes.EncryptToBase64([]byte(name)))
+	}
+
+	if r.Err = c.transfer(ctx, item, key, md, download, upload); r.Err == nil {
+		r.Status, r.Bytes = resultTransferred, item.FileSize
+	}
+	return r
+}
+
+// copyPack copies the pack as is, or rebuilds it if the content or keys of
+// files in it are changed.
+func (c *copier) copyPack(ctx context.Context, item *storage.Item) result {
+	item.Filename = item.ObjectKey
+	r := result{Filename: item.ObjectKey, Key: item.ObjectKey, Status: resultFailed}
+	if _, ok := c.existing[item.ObjectKey]; ok {
+		r.Status = resultSkipped
+		return r
+	}
+
+	if !c.reencrypt && !c.rekey {
+		r.Err = c.transfer(ctx, item, item.ObjectKey, item.Metadata.User(), nopWriter, nopReader)
+	} else {
+		r.Err = c.rebuildPack(ctx, item)
+	}
+
+	if r.Err == nil {
+		r.Status, r.Bytes = resultTransferred, item.FileSize
+	}
+	return r
+}
+
+// transfer downloads the object through a temporary file, which is uploaded
+// to the key of destination.
+func (c *copier) transfer(ctx context.Context, item *storage.Item, key string, md storage.Metadata,
+	download func(io.Writer) io.Writer, upload func(io.Reader) io.Reader) error {
+	fp, err := ioutil.TempFile("", "oss-backup-copy-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fp.Close()
+		_ = os.Remove(fp.Name())
+	}()
+
+	if err := c.src.Download(ctx, item, storage.RewindWriter(fp, download)); err != nil {
+		return fmt.Errorf("download %s: %w", item.ObjectKey, err)
+	}
+
+	if _, err := fp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	dst := &storage.Item{Filename: item.Filename, ObjectKey: key, FileSize: item.FileSize}
+	if err := c.dst.Upload(ctx, dst, storage.RewindReader(fp, upload), md); err != nil {
+		return fmt.Errorf("upload %s: %w", key, err)
+	}
+	return nil
+}
+
+// rebuildPack re-encrypts the files and index of pack, the pack is uploaded
+// to the same key.
+func (c *copier) rebuildPack(ctx context.Context, item *storage.Item) error {
+	files, err := storage.ReadPackIndex(ctx, c.src, item, c.srcAes.ProxyWriter)
+	if err != nil {
+		return err
+	}
+
+	fp, err := ioutil.TempFile("", "oss-backup-copy-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fp.Close()
+		_ = os.Remove(fp.Name())
+	}()
+
+	if err := c.src.Download(ctx, item, storage.RewindWriter(fp, nopWriter)); err != nil {
+		return fmt.Errorf("download %s: %w", item.ObjectKey, err)
+	}
+
+	pack := storage.NewPack()
+	for _, file := range files {
+		plain := bytes.Buffer{}
+		if _, err := io.Copy(c.srcAes.ProxyWriter(&plain), io.NewSectionReader(fp, file.Pack.Offset, file.Pack.Length)); err != nil {
+			return fmt.Errorf("decrypt %s in pack %s: %w", file.Filename, item.ObjectKey, err)
+		}
+
+		data, err := ioutil.ReadAll(c.dstAes.ProxyReader(&plain))
+		if err != nil {
+			return err
+		}
+
+		key, name, err := c.Key(file.ObjectKey, file.Filename)
+		if err != nil {
+			return fmt.Errorf("key of %s in pack %s: %w", file.Filename, item.ObjectKey, err)
+		}
+
+		md := file.Metadata.User()
+		if c.reencrypt {
+			md.ReplaceEncrypted(c.encrypt)
+		}
+		pack.Add(&storage.PackEntry{
+			Key:      key,
+			Filename: name,
+			FileSize: file.FileSize,
+			ModTime:  file.ModTime.Unix(),
+			Metadata: md,
+		}, data)
+	}
+
+	data, md, err := pack.Seal(c.dstAes.ProxyReader)
+	if err != nil {
+		return err
+	}
+
+	dst := &storage.Item{Filename: item.ObjectKey, ObjectKey: item.ObjectKey, FileSize: data.Size()}
+	if err := c.dst.Upload(ctx, dst, storage.RewindReader(data, nopReader), md); err != nil {
+		return fmt.Errorf("upload %s: %w", item.ObjectKey, err)
+	}
+	return nil
+}
+
+func nopWriter(w io.Writer) io.Writer { return w }
+
+func nopReader(r io.Reader) io.Reader { return r }
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopierKey(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs := filepath.ToSlash(filepath.Join(wd, "docs/a.txt"))
+
+	md5 := newTestNamer(t, keyModeMd5, "pw", "")
+	hmac := newTestNamer(t, keyModeHmac, "pw", "")
+	other := newTestNamer(t, keyModeHmac, "other", "")
+
+	tests := []struct {
+		name     string
+		src, dst *objectNamer
+		rekey    bool
+		key      string
+		filename string
+		wantKey  string
+		wantName string
+	}{
+		{"same mode", md5, md5, false, "host/" + md5.Key("docs/a.txt"), "docs/a.txt", "host/" + md5.Key("docs/a.txt"), "docs/a.txt"},
+		{"md5 to hmac", md5, hmac, true, "host/" + md5.Key("docs/a.txt"), "docs/a.txt", "host/" + hmac.Key(abs), abs},
+		{"hmac to md5", hmac, md5, true, "host/" + hmac.Key(abs), abs, "host/" + md5.Key(abs), abs},
+		{"hmac password", hmac, other, true, hmac.Key(abs), abs, other.Key(abs), abs},
+		{"hmac password relative", hmac, other, true, hmac.Key("projects/a.txt"), "projects/a.txt", other.Key("projects/a.txt"), "projects/a.txt"},
+		{"unknown key", md5, hmac, true, "host/other", "docs/a.txt", "host/other", "docs/a.txt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &copier{srcNamer: tt.src, dstNamer: tt.dst, rekey: tt.rekey}
+			key, name, err := c.Key(tt.key, tt.filename)
+			if err != nil {
+				t.Fatalf("Key() error = %v", err)
+			}
+			if key != tt.wantKey || name != tt.wantName {
+				t.Errorf("Key() = %s, %s, want %s, %s", key, name, tt.wantKey, tt.wantName)
+			}
+		})
+	}
+}
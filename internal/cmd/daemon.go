@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/metrics"
+	"oss-backup/pkg/utils"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultRetryDelay = time.Minute
+)
+
+func DaemonCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "run the jobs of configure on schedule",
+	}
+
+	cmd.PersistentFlags().StringArrayP("job", "j", nil, "name of job to schedule, default to all jobs")
+	cmd.PersistentFlags().StringP("metrics-listen", "", "", "address to serve metrics on /metrics, e.g. :9464")
+	cmd.Run = doDaemonCommand
+
+	return cmd
+}
+
+// scheduledJob runs the backup on schedule, the run is skipped if previous
+// one is still running.
+type scheduledJob struct {
+	ctx        context.Context
+	stopping   <-chan struct{}
+	backup     *backupJob
+	retryDelay time.Duration
+	running    int32
+}
+
+func (j *scheduledJob) Run() {
+	log := j.backup.log
+	if !atomic.CompareAndSwapInt32(&j.running, 0, 1) {
+		log.Warn("job is still running, skipped")
+		return
+	}
+	defer atomic.StoreInt32(&j.running, 0)
+
+	for attempt := 0; ; attempt++ {
+		log.Info("job started", "attempt", attempt+1)
+		sum := j.backup.Run(j.ctx)
+		log.Info("job finished", "status", sum.Status(), "exit_code", sum.ExitCode())
+
+		if sum.ExitCode() == ExitOK || attempt >= j.backup.job.Retries || j.ctx.Err() != nil {
+			return
+		}
+
+		log.Warn("job will be retried", "delay", j.retryDelay, "retry", attempt+1, "retries", j.backup.job.Retries)
+		select {
+		case <-time.After(j.retryDelay):
+		case <-j.stopping:
+			return
+		}
+	}
+}
+
+func doDaemonCommand(cmd *cobra.Command, _ []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	jobs := cfg.Jobs
+	if names, _ := cmd.Flags().GetStringArray("job"); len(names) != 0 {
+		jobs = nil
+		for _, name := range names {
+			job := cfg.Jobs.Find(name)
+			if job == nil {
+				FatalConfig(fmt.Sprintf("unknown job %s", name))
+			}
+			jobs = append(jobs, job)
+		}
+	}
+
+	if len(jobs) == 0 {
+		FatalConfig("no job configured")
+	}
+
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	stopping := make(chan struct{})
+	c := cron.New()
+	for _, job := range jobs {
+		sj, err := newScheduledJob(ctx, stopping, cfg, job)
+		if err != nil {
+			FatalConfig(fmt.Sprintf("job %s: %s", job.Name, err))
+		}
+
+		if _, err := c.AddJob(job.Schedule, sj); err != nil {
+			FatalConfig(fmt.Sprintf("job %s: invalid schedule: %s", job.Name, err))
+		}
+	}
+
+	if addr, _ := cmd.Flags().GetString("metrics-listen"); addr != "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			FatalConfig(err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.DefaultRegistry.Handler())
+		srv := &http.Server{Handler: mux}
+		defer func() { _ = srv.Close() }()
+
+		go func() {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				logger.Error("metrics server stopped", "error", err)
+			}
+		}()
+		logger.Info("serving metrics", "address", ln.Addr().String())
+	}
+
+	c.Start()
+	logger.Info("daemon started", "jobs", len(jobs))
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	logger.Info("stopping, waiting for running jobs, interrupt again to abort")
+	close(stopping)
+	stopped := c.Stop()
+	select {
+	case <-stopped.Done():
+	case <-signals:
+		logger.Warn("aborting running jobs")
+		cancel()
+		<-stopped.Done()
+	}
+}
+
+func newScheduledJob(ctx context.Context, stopping <-chan struct{}, cfg *conf.Config, job *conf.Job) (*scheduledJob, error) {
+	bucket := cfg.GetBucket()
+	if job.Bucket != "" {
+		bucket = cfg.FindBucket(job.Bucket)
+	}
+
+	if bucket == nil {
+		return nil, fmt.Errorf("unknown bucket %q", job.Bucket)
+	}
+
+	if len(job.Paths) == 0 {
+		return nil, fmt.Errorf("no paths to backup")
+	}
+
+	b, err := newBackupJob(bucket, job)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.sinks, err = newNotifySinks(cfg); err != nil {
+		return nil, err
+	}
+
+	sj := &scheduledJob{ctx: ctx, stopping: stopping, backup: b, retryDelay: defaultRetryDelay}
+	if job.RetryDelay != "" {
+		if sj.retryDelay, err = utils.ParseDuration(job.RetryDelay); err != nil {
+			return nil, err
+		}
+	}
+	return sj, nil
+}
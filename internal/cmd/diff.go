@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/crypto"
+	"oss-backup/pkg/storage"
+	"oss-backup/pkg/utils"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func DiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff <paths...>",
+		Short: "compare local files with remote objects",
+		Args:  cobra.MinimumNArgs(1),
+	}
+
+	cmd.PersistentFlags().StringP("prefix", "", "", "prefix of object key used by backup")
+	cmd.PersistentFlags().StringP("password", "", "", "password to encrypt filename")
+	cmd.PersistentFlags().StringP("host", "", "", "host of backup, defaults to the hostname")
+	cmd.PersistentFlags().StringP("root", "", "", "root used by backup to derive object keys")
+	cmd.PersistentFlags().BoolP("unchanged", "", false, "print unchanged files too")
+	cmd.PersistentFlags().BoolP("check-content", "", false, "compare mtime in nanoseconds, and ctime and inode of objects without checksum too")
+	cmd.Run = doDiffCommand
+
+	return cmd
+}
+
+type diffStatus string
+
+const (
+	diffAdded     diffStatus = "A"
+	diffModified  diffStatus = "M"
+	diffDeleted   diffStatus = "D"
+	diffUnchanged diffStatus = "="
+	diffErrored   diffStatus = "!"
+)
+
+func doDiffCommand(cmd *cobra.Command, args []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	password, _ := cmd.Flags().GetString("password")
+	if password == "" {
+		FatalConfig("password is required")
+	}
+
+	aes, err := crypto.NewAes(&crypto.AesConfig{Password: password})
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	oss, err := storage.New(currentBucket(cfg))
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	root, _ := cmd.Flags().GetString("root")
+	namer, err := newObjectNamer(currentBucket(cfg), aes, root)
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	// the paths are compared with the recorded filenames
+	names := make([]string, 0, len(args))
+	for _, path := range args {
+		name, err := namer.Name(path)
+		if err != nil {
+			FatalConfig(err)
+		}
+		names = append(names, name)
+	}
+
+	host, _ := cmd.Flags().GetString("host")
+	if host, err = backupHost(host); err != nil {
+		FatalConfig(err)
+	}
+
+	prefix, _ := cmd.Flags().GetString("prefix")
+	prefix = hostPrefix(currentBucket(cfg), prefix, host)
+	remote := make(map[string]*storage.Item)
+	items, wait := listObjects(cmd.Context(), oss, aes, []string{prefix}, nil)
+	for item := range items {
+		// the objects of other hosts sharing the keys are ignored
+		if item.Host == "" || item.Host == host {
+			remote[item.ObjectKey] = item
+		}
+	}
+	exitOnListError(wait)
+
+	counts := make(map[diffStatus]int)
+	unchanged, _ := cmd.Flags().GetBool("unchanged")
+	checkContent, _ := cmd.Flags().GetBool("check-content")
+
+	// the unreadable paths are reported by walk concurrently
+	var mu sync.Mutex
+	report := func(status diffStatus, filename, reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		counts[status]++
+		if status == diffUnchanged && !unchanged {
+			return
+		}
+
+		if reason != "" {
+			reason = " (" + reason + ")"
+		}
+		fmt.Printf("%s %s%s\n", status, filename, reason)
+	}
+
+	fail := func(filename string, err error) {
+		report(diffErrored, filename, err.Error())
+	}
+
+	for filename := range walk(cmd.Context(), args, fail) {
+		stat, err := os.Stat(filename)
+		if err != nil {
+			fail(filename, err)
+			continue
+		}
+
+		name, err := namer.Name(filename)
+		if err != nil {
+			fail(filename, err)
+			continue
+		}
+
+		key := storage.ObjectKey(prefix, namer.Key(name))
+		item, ok := remote[key]
+		if !ok {
+			report(diffAdded, filename, "")
+			continue
+		}
+		delete(remote, key)
+
+		reasons := compareItem(item, stat)
+		if len(reasons) == 0 && checkContent && !sameModTime(item.Metadata, stat) {
+			reasons = append(reasons, "mtime")
+		}
+
+		if len(reasons) == 0 {
+			reason, err := compareContent(aes, filename, stat, item.Metadata, checkContent)
+			if err != nil {
+				fail(filename, err)
+				continue
+			}
+			if reason != "" {
+				reasons = append(reasons, reason)
+			}
+		}
+
+		if len(reasons) != 0 {
+			report(diffModified, filename, strings.Join(reasons, ", "))
+		} else {
+			report(diffUnchanged, filename, "")
+		}
+	}
+
+	// the remaining objects backed up from given paths are deleted locally
+	var deleted []string
+	for _, item := range remote {
+		if underPaths(item.Filename, names) {
+			deleted = append(deleted, item.Filename)
+		}
+	}
+
+	sort.Strings(deleted)
+	for _, filename := range deleted {
+		report(diffDeleted, filename, "")
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "%d added, %d modified, %d deleted, %d unchanged, %d errored\n",
+		counts[diffAdded], counts[diffModified], counts[diffDeleted], counts[diffUnchanged], counts[diffErrored])
+	if counts[diffErrored] != 0 {
+		Exit(ExitPartialFailure)
+	}
+	if counts[diffAdded]+counts[diffModified]+counts[diffDeleted] != 0 {
+		Exit(ExitDifferences)
+	}
+}
+
+// compareItem returns the reasons why the remote object differs from local
+func compareItem(item *storage.Item, stat os.FileInfo) []string {
+	var reasons []string
+	if item.FileSize != stat.Size() {
+		reasons = append(reasons, fmt.Sprintf("size %d -> %d", item.FileSize, stat.Size()))
+	}
+
+	if item.ModTime.Unix() != stat.ModTime().Unix() {
+		reasons = append(reasons, fmt.Sprintf("mtime %s -> %s",
+			item.ModTime.Format(time.RFC3339), stat.ModTime().Format(time.RFC3339)))
+	}
+	return reasons
+}
+
+// compareContent compares the checksum of file if it's recorded in md, the
+// ctime and inode are compared instead if check is set. The reason is empty
+// if the content is unchanged.
+func compareContent(aes *crypto.Aes, filename string, stat os.FileInfo, md storage.Metadata, check bool) (string, error) {
+	if remote := md.Checksum(); remote != "" {
+		checksum, err := fileChecksum(filename)
+		if err != nil {
+			return "", err
+		}
+
+		if string(aes.DecryptFromBase64(remote)) != checksum {
+			return "content", nil
+		}
+		return "", nil
+	}
+
+	if check {
+		ctime, inode := md.ChangeTime()
+		if localCtime, localInode := utils.ChangeTime(stat); ctime != 0 && (ctime != localCtime || inode != localInode) {
+			return "ctime", nil
+		}
+	}
+	return "", nil
+}
+
+// underPaths reports whether the filename is one of paths or inside them
+func underPaths(filename string, paths []string) bool {
+	for _, path := range paths {
+		if path == "." {
+			return true
+		}
+
+		path = strings.TrimRight(path, "/\\")
+		if filename == path || strings.HasPrefix(filename, path+"/") || strings.HasPrefix(filename, path+"\\") {
+			return true
+		}
+	}
+	return false
+}
@@ -2,16 +2,19 @@ package cmd
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"io"
 	"os"
 	"oss-backup/pkg/conf"
 	"oss-backup/pkg/crypto"
 	"oss-backup/pkg/limiter"
+	"oss-backup/pkg/lock"
+	"oss-backup/pkg/logger"
 	"oss-backup/pkg/storage"
 	"oss-backup/pkg/utils"
 	"path"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -24,6 +27,10 @@ func DownloadCommand() *cobra.Command {
 
 	cmd.PersistentFlags().StringP("dir", "", "", "output dir")
 	cmd.PersistentFlags().StringP("password", "", "", "password to encrypt filename")
+	cmd.PersistentFlags().StringP("limit-download", "", "", "max download bandwidth of all workers, e.g. 10MiB/s")
+	cmd.PersistentFlags().IntP("max-concurrency", "", 1, "number of max download concurrency")
+	cmd.PersistentFlags().BoolP("fail-fast", "", false, "abort all downloads once any of them failed")
+	addFilterFlags(cmd.PersistentFlags())
 	cmd.Run = doDownloadCommand
 
 	return cmd
@@ -34,73 +41,116 @@ func doDownloadCommand(cmd *cobra.Command, args []string) {
 
 	password, _ := cmd.Flags().GetString("password")
 	if password == "" {
-		log.Fatal("password is required")
+		FatalConfig("password is required")
 	}
 
 	aes, err := crypto.NewAes(&crypto.AesConfig{Password: password})
 	if err != nil {
-		log.Fatal(err)
+		FatalConfig(err)
 	}
 
-	oss, err := storage.NewAliYunOSS(cfg.GetBucket())
+	oss, err := storage.New(currentBucket(cfg))
 	if err != nil {
-		log.Fatal(err)
+		FatalConfig(err)
 	}
-	uploader = oss
 
-	if len(args) == 0 {
-		args = append(args, "")
+	filter, err := newItemFilter(cmd.Flags())
+	if err != nil {
+		FatalConfig(err)
 	}
 
-	wg := sync.WaitGroup{}
-	ch := make(chan *storage.Item, 1024)
-	for _, name := range args {
-		wg.Add(1)
-		go func(name string) {
-			for item := range oss.ListObject(name) {
-				ch <- item
-			}
-			wg.Done()
-		}(name)
+	limit, _ := cmd.Flags().GetString("limit-download")
+	bytesPerSecond, err := utils.ParseRate(limit)
+	if err != nil {
+		FatalConfig(err)
 	}
-
-	go func() {
-		wg.Wait()
-		close(ch)
-	}()
+	bw := limiter.NewBandwidthLimiter(bytesPerSecond)
 
 	dir, _ := cmd.Flags().GetString("dir")
-	cl := limiter.NewConcurrencyLimiter(1)
+	maxConcurrency, _ := cmd.Flags().GetInt("max-concurrency")
+	failFast, _ := cmd.Flags().GetBool("fail-fast")
+
+	// locked after the flags are validated, it's released before any exit
+	l, err := lock.Shared(cmd.Context(), oss)
+	if err != nil {
+		logger.Error("lock repository failed", "error", err)
+		Exit(ExitTotalFailure)
+	}
+	ch, wait := listObjects(cmd.Context(), oss, aes, args, filter)
+
+	ctx, tracker, stopProgress := startProgress(cmd.Context(), "downloaded")
+	pool := limiter.NewPool(ctx, maxConcurrency, failFast)
+	stop := handleInterrupt(pool)
+
+	sum := newSummary("downloaded")
+	drained := true
 	for item := range ch {
-		cl.Execute(func(args ...interface{}) {
-			download(args[0].(string), args[1].(*storage.Item), args[2].(*crypto.Aes))
-		}, dir, item, aes)
+		sum.Scan()
+		tracker.Expect(item.FileSize)
+
+		item := item
+		if err := pool.Go(func(ctx context.Context) error {
+			start := time.Now()
+			r := download(ctx, oss, dir, item, aes, bw)
+			r.Duration = time.Since(start)
+			logResult(logger.Default(), "downloaded", r)
+
+			tracker.Finish(item.FileSize, r.Status == resultFailed)
+			sum.Add(r)
+			return r.Err
+		}); err != nil {
+			sum.Interrupt()
+			drained = false
+			break
+		}
+	}
+
+	_ = pool.Wait()
+	stop()
+	stopProgress()
+	if err := l.Release(); err != nil {
+		logger.Warn("release lock failed", "error", err)
+	}
+
+	// the listing is still running if interrupted
+	if drained {
+		if err := wait(); err != nil {
+			logger.Error("list objects failed", "error", err)
+			sum.Add(result{Filename: "(listing)", Status: resultFailed, Err: err})
+		}
 	}
 
-	cl.Wait()
+	sum.Print(os.Stderr)
+	Exit(sum.ExitCode())
 }
 
-func download(dir string, item *storage.Item, aes *crypto.Aes) {
-	filename := normalizeFilename(string(aes.DecryptFromBase64(item.Metadata.Filename())))
+func download(ctx context.Context, uploader storage.Uploader, dir string, item *storage.Item, aes *crypto.Aes, bw *limiter.BandwidthLimiter) result {
+	filename := normalizeFilename(item.Filename)
+	r := result{Filename: filename, Key: item.ObjectKey, Status: resultFailed}
 	if !utils.Exists(dir) {
 		if err := os.MkdirAll(dir, os.ModeDir); err != nil {
-			log.Printf("create dir: %s", err)
-			return
+			r.Err = fmt.Errorf("create dir: %w", err)
+			return r
 		}
 	}
-	item.Filename = filename
 
 	fp, err := os.Create(path.Join(dir, filename))
 	if err != nil {
-		log.Printf("open file: %s", err)
-		return
+		r.Err = fmt.Errorf("open file: %w", err)
+		return r
 	}
-
-	buf := aes.ProxyWriter(fp)
-	if err := uploader.Download(context.Background(), item, buf); err != nil {
-		log.Printf("save file: %s", err)
-		return
+	defer func() { _ = fp.Close() }()
+
+	buf := storage.RewindWriter(fp, func(w io.Writer) io.Writer {
+		return bw.Writer(ctx, aes.ProxyWriter(w))
+	})
+	if err := uploader.Download(ctx, item, buf); err != nil {
+		r.Err = fmt.Errorf("save file %s: %w", filename, err)
+		return r
 	}
+
+	r.Status, r.Bytes = resultTransferred, item.FileSize
+	return r
 }
 
 func normalizeFilename(filename string) string {
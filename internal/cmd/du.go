@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/crypto"
+	"oss-backup/pkg/storage"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func DiskUsageCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "du",
+		Short: "summarize size of remote objects by directory",
+	}
+
+	cmd.PersistentFlags().StringP("password", "", "", "password to encrypt filename")
+	cmd.PersistentFlags().IntP("depth", "d", 0, "max depth of directories to print, 0 for unlimited")
+	cmd.PersistentFlags().BoolP("bytes", "b", false, "print exact size in bytes instead of human readable")
+	cmd.Run = doDiskUsageCommand
+
+	return cmd
+}
+
+func doDiskUsageCommand(cmd *cobra.Command, args []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	password, _ := cmd.Flags().GetString("password")
+	if password == "" {
+		FatalConfig("password is required")
+	}
+
+	aes, err := crypto.NewAes(&crypto.AesConfig{Password: password})
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	oss, err := storage.New(currentBucket(cfg))
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	root := newDirNode("")
+	items, wait := listObjects(cmd.Context(), oss, aes, args, nil)
+	for item := range items {
+		root.Add(item)
+	}
+	exitOnListError(wait)
+
+	depth, _ := cmd.Flags().GetInt("depth")
+	exact, _ := cmd.Flags().GetBool("bytes")
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "SIZE\tFILES\tPATH")
+	root.Walk(func(dir string, level int, node *dirNode) {
+		if depth == 0 || level <= depth {
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\n", formatSize(node.size, exact), node.files, dir)
+		}
+	})
+	_ = tw.Flush()
+}
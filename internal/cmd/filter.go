@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"oss-backup/pkg/storage"
+	"oss-backup/pkg/utils"
+	"regexp"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// itemFilter selects the objects by their decrypted metadata
+type itemFilter struct {
+	paths     []*regexp.Regexp
+	newerThan time.Time
+	olderThan time.Time
+	minSize   int64
+	maxSize   int64
+	host      string
+}
+
+func addFilterFlags(flags *pflag.FlagSet) {
+	flags.StringArrayP("path", "", nil, "glob of decrypted filename, e.g. 'home/alice/docs/**', can be repeated")
+	flags.StringP("newer-than", "", "", "only objects modified after the date or duration, e.g. 2006-01-02 or 7d")
+	flags.StringP("older-than", "", "", "only objects modified before the date or duration")
+	flags.StringP("min-size", "", "", "only objects not smaller than the size, e.g. 1MiB")
+	flags.StringP("max-size", "", "", "only objects not larger than the size")
+	flags.StringP("host", "", "", "only objects backed up from the host")
+}
+
+func newItemFilter(flags *pflag.FlagSet) (*itemFilter, error) {
+	f := &itemFilter{minSize: -1, maxSize: -1}
+	f.host, _ = flags.GetString("host")
+
+	patterns, _ := flags.GetStringArray("path")
+	for _, pattern := range patterns {
+		re, err := utils.CompileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.paths = append(f.paths, re)
+	}
+
+	now := time.Now()
+	for name, t := range map[string]*time.Time{"newer-than": &f.newerThan, "older-than": &f.olderThan} {
+		if v, _ := flags.GetString(name); v != "" {
+			var err error
+			if *t, err = utils.ParseTimeSpec(v, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for name, n := range map[string]*int64{"min-size": &f.minSize, "max-size": &f.maxSize} {
+		if v, _ := flags.GetString(name); v != "" {
+			var err error
+			if *n, err = utils.ParseBytes(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return f, nil
+}
+
+func (f *itemFilter) Match(item *storage.Item) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.paths) != 0 {
+		matched, filename := false, utils.NormalizePath(item.Filename)
+		for _, re := range f.paths {
+			if matched = re.MatchString(filename); matched {
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	switch {
+	case !f.newerThan.IsZero() && !item.ModTime.After(f.newerThan):
+		return false
+	case !f.olderThan.IsZero() && !item.ModTime.Before(f.olderThan):
+		return false
+	case f.minSize >= 0 && item.FileSize < f.minSize:
+		return false
+	case f.maxSize >= 0 && item.FileSize > f.maxSize:
+		return false
+	case f.host != "" && item.Host != f.host:
+		return false
+	}
+	return true
+}
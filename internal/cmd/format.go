@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"oss-backup/pkg/storage"
+	"oss-backup/pkg/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type itemPrinter interface {
+	Print(item *storage.Item) error
+	Flush() error
+}
+
+func newItemPrinter(w io.Writer, format string, long, exact bool) (itemPrinter, error) {
+	switch format {
+	case "table", "":
+		return newTablePrinter(w, long, exact), nil
+	case "json":
+		return &jsonPrinter{w: w}, nil
+	case "jsonl":
+		return &jsonlPrinter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		return newCsvPrinter(w), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func itemLess(sortBy string, reverse bool) (func(a, b *storage.Item) bool, error) {
+	var less func(a, b *storage.Item) bool
+	switch sortBy {
+	case "":
+		return nil, nil
+	case "name":
+		less = func(a, b *storage.Item) bool { return a.Filename < b.Filename }
+	case "size":
+		less = func(a, b *storage.Item) bool { return a.FileSize < b.FileSize }
+	case "mtime":
+		less = func(a, b *storage.Item) bool { return a.ModTime.Before(b.ModTime) }
+	case "key":
+		less = func(a, b *storage.Item) bool { return a.ObjectKey < b.ObjectKey }
+	default:
+		return nil, fmt.Errorf("unknown sort key %q", sortBy)
+	}
+
+	if reverse {
+		return func(a, b *storage.Item) bool { return less(b, a) }, nil
+	}
+	return less, nil
+}
+
+type tablePrinter struct {
+	tw     *tabwriter.Writer
+	long   bool
+	exact  bool
+	header bool
+}
+
+func newTablePrinter(w io.Writer, long, exact bool) *tablePrinter {
+	return &tablePrinter{tw: tabwriter.NewWriter(w, 0, 8, 2, ' ', 0), long: long, exact: exact}
+}
+
+func (p *tablePrinter) Print(item *storage.Item) error {
+	if !p.header {
+		p.header = true
+		if p.long {
+			_, _ = fmt.Fprint(p.tw, "KEY\t")
+		}
+		_, _ = fmt.Fprintln(p.tw, "MODIFIED\tSIZE\tFILENAME")
+	}
+
+	size := utils.FormatBytes(item.FileSize)
+	if p.exact {
+		size = strconv.FormatInt(item.FileSize, 10)
+	}
+
+	if p.long {
+		_, _ = fmt.Fprintf(p.tw, "%s\t", item.ObjectKey)
+	}
+	_, err := fmt.Fprintf(p.tw, "%s\t%s\t%s\n", item.ModTime.Format(time.RFC3339), size, item.Filename)
+	return err
+}
+
+func (p *tablePrinter) Flush() error {
+	return p.tw.Flush()
+}
+
+type jsonPrinter struct {
+	w     io.Writer
+	items []*storage.Item
+}
+
+func (p *jsonPrinter) Print(item *storage.Item) error {
+	p.items = append(p.items, item)
+	return nil
+}
+
+func (p *jsonPrinter) Flush() error {
+	if p.items == nil {
+		p.items = []*storage.Item{}
+	}
+
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p.items)
+}
+
+type jsonlPrinter struct {
+	enc *json.Encoder
+}
+
+func (p *jsonlPrinter) Print(item *storage.Item) error {
+	return p.enc.Encode(item)
+}
+
+func (p *jsonlPrinter) Flush() error {
+	return nil
+}
+
+type csvPrinter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCsvPrinter(w io.Writer) *csvPrinter {
+	return &csvPrinter{w: csv.NewWriter(w)}
+}
+
+func (p *csvPrinter) Print(item *storage.Item) error {
+	if !p.header {
+		p.header = true
+		if err := p.w.Write([]string{"object_key", "filename", "file_size", "mod_time", "metadata"}); err != nil {
+			return err
+		}
+	}
+
+	return p.w.Write([]string{
+		item.ObjectKey,
+		item.Filename,
+		strconv.FormatInt(item.FileSize, 10),
+		item.ModTime.Format(time.RFC3339),
+		formatMetadata(item.Metadata),
+	})
+}
+
+func (p *csvPrinter) Flush() error {
+	p.w.Flush()
+	return p.w.Error()
+}
+
+// formatMetadata formats metadata into sorted key=value pairs
+func formatMetadata(md storage.Metadata) string {
+	pairs := make([]string, 0, len(md))
+	for k, v := range md {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ";")
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/storage"
+	"strings"
+)
+
+// backupHost returns the host recorded in objects, the hostname by default
+func backupHost(host string) (string, error) {
+	if host == "" {
+		var err error
+		if host, err = os.Hostname(); err != nil {
+			return "", err
+		}
+	}
+
+	if strings.ContainsAny(host, "/\\") {
+		return "", fmt.Errorf("invalid host %q", host)
+	}
+	return host, nil
+}
+
+// hostPrefix returns the prefix of objects backed up from host, which is
+// the directory of host under prefix if host_namespace of bucket is enabled
+func hostPrefix(bucket *conf.Bucket, prefix, host string) string {
+	if !bucket.HostNamespace {
+		return prefix
+	}
+	return storage.ObjectKey(prefix, host)
+}
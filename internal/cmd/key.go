@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/crypto"
+	"oss-backup/pkg/utils"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	keyModeMd5  = conf.KeyModeMd5
+	keyModeHmac = conf.KeyModeHmac
+)
+
+// objectNamer derives the recorded filename and object key of local files.
+// The keys are the md5 of filenames as given by default, or the HMAC of paths
+// relative to root which are unguessable and kept if the root is moved.
+type objectNamer struct {
+	aes  *crypto.Aes
+	hmac bool
+	root string
+}
+
+func newObjectNamer(bucket *conf.Bucket, aes *crypto.Aes, root string) (*objectNamer, error) {
+	n := &objectNamer{aes: aes}
+	switch bucket.KeyMode {
+	case "", keyModeMd5:
+		if root != "" {
+			return nil, fmt.Errorf("root requires key_mode %s of bucket %s", keyModeHmac, bucket.Alias)
+		}
+	case keyModeHmac:
+		n.hmac = true
+	default:
+		return nil, fmt.Errorf("unknown key_mode %q of bucket %s", bucket.KeyMode, bucket.Alias)
+	}
+
+	if root != "" {
+		var err error
+		if n.root, err = filepath.Abs(root); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// Name returns the filename recorded in object, which is the slash separated
+// path relative to root (or the absolute path if no root) in hmac mode.
+func (n *objectNamer) Name(filename string) (string, error) {
+	if !n.hmac {
+		return filename, nil
+	}
+
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+
+	if n.root == "" {
+		return filepath.ToSlash(abs), nil
+	}
+
+	rel, err := filepath.Rel(n.root, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file %s is outside of root %s", filename, n.root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Key returns the object key (without prefix) of the recorded filename
+func (n *objectNamer) Key(name string) string {
+	if n.hmac {
+		return n.aes.HashKey(name)
+	}
+	return utils.Md5(name)
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"os"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/crypto"
+	"oss-backup/pkg/utils"
+	"path/filepath"
+	"testing"
+)
+
+func newTestNamer(t *testing.T, keyMode, password, root string) *objectNamer {
+	aes, err := crypto.NewAes(&crypto.AesConfig{Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := newObjectNamer(&conf.Bucket{Alias: "test", KeyMode: keyMode}, aes, root)
+	if err != nil {
+		t.Fatalf("newObjectNamer() error = %v", err)
+	}
+	return n
+}
+
+func TestNewObjectNamer(t *testing.T) {
+	aes, err := crypto.NewAes(&crypto.AesConfig{Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		keyMode string
+		root    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{keyModeMd5, "", false},
+		{keyModeHmac, "", false},
+		{keyModeHmac, "/data", false},
+		{"", "/data", true},
+		{keyModeMd5, "/data", true},
+		{"sha1", "", true},
+	}
+
+	for _, tt := range tests {
+		_, err := newObjectNamer(&conf.Bucket{Alias: "test", KeyMode: tt.keyMode}, aes, tt.root)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newObjectNamer(%q, %q) error = %v, want error %v", tt.keyMode, tt.root, err, tt.wantErr)
+		}
+	}
+}
+
+func TestObjectNamerName(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		keyMode  string
+		root     string
+		filename string
+		want     string
+		wantErr  bool
+	}{
+		{"md5 keeps filename", keyModeMd5, "", "docs/a.txt", "docs/a.txt", false},
+		{"md5 keeps absolute", keyModeMd5, "", "/data/docs/a.txt", "/data/docs/a.txt", false},
+		{"hmac absolute", keyModeHmac, "", "/data/docs/../a.txt", "/data/a.txt", false},
+		{"hmac relative to wd", keyModeHmac, "", "docs/a.txt", filepath.ToSlash(filepath.Join(wd, "docs/a.txt")), false},
+		{"hmac under root", keyModeHmac, "/data", "/data/docs/a.txt", "docs/a.txt", false},
+		{"hmac root itself", keyModeHmac, "/data", "/data", ".", false},
+		{"hmac root with slash", keyModeHmac, "/data/", "/data/a.txt", "a.txt", false},
+		{"hmac outside root", keyModeHmac, "/data", "/etc/passwd", "", true},
+		{"hmac sibling of root", keyModeHmac, "/data", "/data2/a.txt", "", true},
+		{"hmac parent of root", keyModeHmac, "/data/docs", "/data", "", true},
+		{"hmac dotted name under root", keyModeHmac, "/data", "/data/..a", "..a", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newTestNamer(t, tt.keyMode, "pw", tt.root)
+			got, err := n.Name(tt.filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Name(%q) error = %v, want error %v", tt.filename, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Name(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObjectNamerKey(t *testing.T) {
+	md5 := newTestNamer(t, keyModeMd5, "pw", "")
+	if got := md5.Key("/data/a.txt"); got != utils.Md5("/data/a.txt") {
+		t.Errorf("md5 Key() = %s", got)
+	}
+	if md5.Key("/data/a.txt") != newTestNamer(t, "", "other", "").Key("/data/a.txt") {
+		t.Error("md5 keys should not depend on password")
+	}
+
+	hmac := newTestNamer(t, keyModeHmac, "pw", "")
+	key := hmac.Key("/data/a.txt")
+	if len(key) != 64 || key == utils.Md5("/data/a.txt") {
+		t.Errorf("hmac Key() = %s", key)
+	}
+	if key != newTestNamer(t, keyModeHmac, "pw", "/data").Key("/data/a.txt") {
+		t.Error("hmac keys should be stable for the same password")
+	}
+	if key == newTestNamer(t, keyModeHmac, "other", "").Key("/data/a.txt") {
+		t.Error("hmac keys should depend on password")
+	}
+	if key == hmac.Key("/data/b.txt") {
+		t.Error("hmac keys should depend on name")
+	}
+}
@@ -1,13 +1,19 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"os/signal"
 	"oss-backup/pkg/conf"
 	"oss-backup/pkg/crypto"
+	"oss-backup/pkg/lock"
+	"oss-backup/pkg/logger"
 	"oss-backup/pkg/storage"
+	"sort"
 	"sync"
-	"time"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -19,6 +25,14 @@ func ListCommand() *cobra.Command {
 	}
 
 	cmd.PersistentFlags().StringP("password", "", "", "password to encrypt filename")
+	cmd.PersistentFlags().StringP("format", "f", "table", "output format, one of table, json, jsonl and csv")
+	cmd.PersistentFlags().StringP("sort", "s", "", "sort objects by name, size, mtime or key")
+	cmd.PersistentFlags().BoolP("reverse", "r", false, "reverse the order of sorting")
+	cmd.PersistentFlags().BoolP("long", "l", false, "print object key in table")
+	cmd.PersistentFlags().BoolP("bytes", "b", false, "print exact size in bytes instead of human readable")
+	cmd.PersistentFlags().BoolP("tree", "t", false, "print objects as a tree of directories")
+	cmd.PersistentFlags().IntP("depth", "d", 0, "max depth of the tree, 0 for unlimited")
+	addFilterFlags(cmd.PersistentFlags())
 	cmd.Run = doListCommand
 
 	return cmd
@@ -29,57 +43,212 @@ func doListCommand(cmd *cobra.Command, args []string) {
 
 	password, _ := cmd.Flags().GetString("password")
 	if password == "" {
-		log.Fatal("password is required")
+		FatalConfig("password is required")
 	}
 
 	aes, err := crypto.NewAes(&crypto.AesConfig{Password: password})
 	if err != nil {
-		log.Fatal(err)
+		FatalConfig(err)
 	}
 
-	oss, err := storage.NewAliYunOSS(cfg.GetBucket())
+	oss, err := storage.New(currentBucket(cfg))
 	if err != nil {
-		log.Fatal(err)
+		FatalConfig(err)
 	}
 
-	if len(args) == 0 {
-		args = append(args, "")
+	ignoreBrokenPipe()
+	format, _ := cmd.Flags().GetString("format")
+	long, _ := cmd.Flags().GetBool("long")
+	exact, _ := cmd.Flags().GetBool("bytes")
+	printer, err := newItemPrinter(os.Stdout, format, long, exact)
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	filter, err := newItemFilter(cmd.Flags())
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	sortBy, _ := cmd.Flags().GetString("sort")
+	reverse, _ := cmd.Flags().GetBool("reverse")
+	less, err := itemLess(sortBy, reverse)
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	items, wait := listObjects(cmd.Context(), oss, aes, args, filter)
+	if tree, _ := cmd.Flags().GetBool("tree"); tree {
+		root := newDirNode("")
+		for item := range items {
+			root.Add(item)
+		}
+		exitOnListError(wait)
+
+		depth, _ := cmd.Flags().GetInt("depth")
+		root.PrintTree(os.Stdout, depth, exact)
+		return
+	}
+
+	if less != nil {
+		var sorted []*storage.Item
+		for item := range items {
+			sorted = append(sorted, item)
+		}
+		exitOnListError(wait)
+		sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+
+		ch := make(chan *storage.Item, len(sorted))
+		for _, item := range sorted {
+			ch <- item
+		}
+		close(ch)
+		items = ch
+	}
+
+	for item := range items {
+		if err := printer.Print(item); err != nil {
+			exitOnWriteError(err)
+		}
+	}
+
+	if err := printer.Flush(); err != nil {
+		exitOnWriteError(err)
+	}
+	exitOnListError(wait)
+}
+
+// ignoreBrokenPipe makes the writes to a closed pipe fail with EPIPE rather
+// than killing the process by SIGPIPE, so that they can be handled.
+func ignoreBrokenPipe() {
+	signal.Ignore(syscall.SIGPIPE)
+}
+
+// exitOnWriteError exits quietly if the reader of output is gone, e.g. piped
+// to head, otherwise exits with ExitTotalFailure
+func exitOnWriteError(err error) {
+	if errors.Is(err, syscall.EPIPE) {
+		Exit(ExitOK)
+	}
+
+	logger.Error("write output failed", "error", err)
+	Exit(ExitTotalFailure)
+}
+
+// exitOnListError exits with ExitFailure if listing objects failed
+func exitOnListError(wait func() error) {
+	if err := wait(); err != nil {
+		logger.Error("list objects failed", "error", err)
+		Exit(ExitFailure)
+	}
+}
+
+// listObjects lists objects under all prefixes (or the whole bucket) with
+// the filename decrypted, the objects not matched by filter are dropped.
+// A file may be uploaded as an object and in packs, only the newest one is
+// listed, so the items are sent after all prefixes are listed.
+// The first error of listing is returned by wait after the channel closed.
+func listObjects(ctx context.Context, uploader storage.Uploader, aes *crypto.Aes, prefixes []string, filter *itemFilter) (<-chan *storage.Item, func() error) {
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
 	}
 
 	wg := sync.WaitGroup{}
 	ch := make(chan *storage.Item, 1024)
-	for _, name := range args {
+	errs := make([]error, len(prefixes))
+	found := make([]newestItems, len(prefixes))
+	for i, name := range prefixes {
 		wg.Add(1)
-		go func(name string) {
-			for item := range oss.ListObject(name) {
-				ch <- item
+		go func(i int, name string) {
+			defer wg.Done()
+
+			newest := make(newestItems)
+			items, wait := uploader.ListObject(ctx, name)
+			for item := range items {
+				if item.Metadata.Kind() == lock.Kind {
+					continue
+				}
+
+				if item.Metadata.Kind() == storage.KindPack {
+					if err := newest.Read(ctx, uploader, aes, item); err != nil && errs[i] == nil {
+						errs[i] = err
+					}
+					continue
+				}
+
+				item.Filename = string(aes.DecryptFromBase64(item.Metadata.Filename()))
+				newest.Add(item)
+			}
+
+			if err := wait(); err != nil {
+				errs[i] = err
 			}
-			wg.Done()
-		}(name)
+			found[i] = newest
+		}(i, name)
 	}
 
 	go func() {
+		defer close(ch)
 		wg.Wait()
-		close(ch)
+
+		// the prefixes may overlap
+		newest := make(newestItems)
+		for _, items := range found {
+			for _, item := range items {
+				newest.Add(item)
+			}
+		}
+
+		for _, item := range newest {
+			item.Host = string(aes.DecryptFromBase64(item.Metadata.Host()))
+			if filter.Match(item) {
+				ch <- item
+			}
+		}
 	}()
 
-	for item := range ch {
-		fmt.Printf("%s -> %s(%s: %s)\n", item.ObjectKey, aes.DecryptFromBase64(item.Metadata.Filename()),
-			time.Unix(item.Metadata.ModTime(), 0).Format(time.RFC3339), bytesCount(item.Metadata.FileSize()))
+	return ch, func() error {
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 }
 
-func bytesCount(n int) string {
+// newestItems keeps the newest item of each key, which is an object or a
+// file in pack
+type newestItems map[string]*storage.Item
+
+// Add keeps the item if it's newer than the one of same key, the object
+// wins the file in pack of the same mtime, and the newer pack wins the
+// older one.
+func (ni newestItems) Add(item *storage.Item) {
+	old, ok := ni[item.ObjectKey]
 	switch {
-	case n < 1024:
-		return fmt.Sprintf("%dB", n)
-	case n >= 1024 && n < 1024*1024:
-		return fmt.Sprintf("%.2fKB", float64(n)/1024)
-	case n >= 1024*1024 && n < 1024*1024*1024:
-		return fmt.Sprintf("%.2fKB", float64(n)/1024/1024)
-	case n >= 1024*1024*1024 && n < 1024*1024*1024*1024:
-		return fmt.Sprintf("%.2fMB", float64(n)/1024/1024/1024)
-	default:
-		return fmt.Sprintf("%.2fGB", float64(n)/1024/1024/1024/1024)
+	case !ok:
+	case !item.ModTime.Equal(old.ModTime):
+		if item.ModTime.Before(old.ModTime) {
+			return
+		}
+	case old.Pack == nil:
+		return
+	case item.Pack != nil && item.Pack.Object < old.Pack.Object:
+		return
+	}
+	ni[item.ObjectKey] = item
+}
+
+// Read adds the items in the index of pack
+func (ni newestItems) Read(ctx context.Context, uploader storage.Uploader, aes *crypto.Aes, pack *storage.Item) error {
+	items, err := storage.ReadPackIndex(ctx, uploader, pack, aes.ProxyWriter)
+	if err != nil {
+		return fmt.Errorf("index of pack %s: %w", pack.ObjectKey, err)
+	}
+
+	for _, item := range items {
+		ni.Add(item)
 	}
+	return nil
 }
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"oss-backup/pkg/storage"
+	"testing"
+	"time"
+)
+
+func TestNewestItems(t *testing.T) {
+	object := func(mtime int64) *storage.Item {
+		return &storage.Item{ObjectKey: "a", ModTime: time.Unix(mtime, 0)}
+	}
+	packed := func(mtime int64, pack string) *storage.Item {
+		return &storage.Item{ObjectKey: "a", ModTime: time.Unix(mtime, 0), Pack: &storage.PackRef{Object: pack}}
+	}
+
+	tests := []struct {
+		name  string
+		items []*storage.Item
+		want  int
+	}{
+		{"single", []*storage.Item{object(1)}, 0},
+		{"newer object", []*storage.Item{packed(1, "packs/1"), object(2)}, 1},
+		{"newer pack", []*storage.Item{object(1), packed(2, "packs/1")}, 1},
+		{"older pack", []*storage.Item{object(2), packed(1, "packs/2")}, 0},
+		{"object wins same mtime", []*storage.Item{object(1), packed(1, "packs/1")}, 0},
+		{"object wins same mtime listed later", []*storage.Item{packed(1, "packs/1"), object(1)}, 1},
+		{"newer pack wins same mtime", []*storage.Item{packed(1, "packs/2"), packed(1, "packs/1")}, 0},
+		{"newest of all", []*storage.Item{packed(3, "packs/1"), object(2), packed(1, "packs/2")}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newest := make(newestItems)
+			for _, item := range tt.items {
+				newest.Add(item)
+			}
+
+			if len(newest) != 1 || newest["a"] != tt.items[tt.want] {
+				t.Errorf("newest = %+v, want item %d", newest["a"], tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"oss-backup/pkg/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	filesTotal = metrics.NewCounterVec("oss_backup_files_total",
+		"Files processed by backup.", "job", "status")
+	uploadedBytesTotal = metrics.NewCounterVec("oss_backup_uploaded_bytes_total",
+		"Bytes uploaded by backup.", "job")
+	runsTotal = metrics.NewCounterVec("oss_backup_runs_total",
+		"Runs of backup by status.", "job", "status")
+	lastRunDuration = metrics.NewGaugeVec("oss_backup_last_run_duration_seconds",
+		"Duration of the last run of backup.", "job")
+	lastRunExitCode = metrics.NewGaugeVec("oss_backup_last_run_exit_code",
+		"Exit code of the last run of backup.", "job")
+	lastRunTimestamp = metrics.NewGaugeVec("oss_backup_last_run_timestamp_seconds",
+		"Unix timestamp of the last run of backup.", "job")
+	lastSuccessTimestamp = metrics.NewGaugeVec("oss_backup_last_success_timestamp_seconds",
+		"Unix timestamp of the last successful run of backup.", "job")
+
+	storageRequestsTotal = metrics.NewCounterVec("oss_backup_storage_requests_total",
+		"Requests of storage by method and result.", "method", "result")
+	storageRequestDuration = metrics.NewHistogramVec("oss_backup_storage_request_duration_seconds",
+		"Latency of storage requests by method.", metrics.DefBuckets, "method")
+)
+
+// record adds the summary of a run to the metrics of job
+func (s *summary) record(job string) {
+	code, status := s.ExitCode(), s.Status()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filesTotal.Add(float64(s.transferred), job, "uploaded")
+	filesTotal.Add(float64(s.skipped), job, "skipped")
+	filesTotal.Add(float64(len(s.failed)), job, "failed")
+	uploadedBytesTotal.Add(float64(s.bytes), job)
+	runsTotal.Inc(job, status)
+
+	now := float64(time.Now().Unix())
+	lastRunDuration.Set(time.Since(s.start).Seconds(), job)
+	lastRunExitCode.Set(float64(code), job)
+	lastRunTimestamp.Set(now, job)
+	if code == ExitOK {
+		lastSuccessTimestamp.Set(now, job)
+	}
+}
+
+// restoreLastSuccess loads the timestamps of last success from the textfile
+// written by previous runs, so that they are kept if this run failed.
+func restoreLastSuccess(filename string) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer func() { _ = fp.Close() }()
+
+	prefix := `oss_backup_last_success_timestamp_seconds{job="`
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, prefix)
+		if i := strings.Index(line, `"} `); i > 0 {
+			if ts, err := strconv.ParseFloat(line[i+3:], 64); err == nil {
+				lastSuccessTimestamp.Set(ts, line[:i])
+			}
+		}
+	}
+}
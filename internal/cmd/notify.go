@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/notify"
+	"time"
+)
+
+const (
+	notifyTimeout = time.Minute
+	// maxNotifyErrors is the max number of failures in a notification
+	maxNotifyErrors = 10
+)
+
+func newNotifySinks(cfg *conf.Config) ([]*notify.Sink, error) {
+	var sinks []*notify.Sink
+	for _, nc := range cfg.Notifications {
+		sink, err := notify.New(nc)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
+
+// notify sends the summary to all sinks, the failures are only logged
+func (b *backupJob) notify(sum *summary) {
+	if len(b.sinks) == 0 {
+		return
+	}
+
+	// the context of run may be canceled already
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	event := sum.event(b.job.Name, b.host)
+	for _, sink := range b.sinks {
+		if err := sink.Notify(ctx, event); err != nil {
+			b.log.Error("notify failed", "sink", sink.Name, "error", err)
+		}
+	}
+}
+
+func (s *summary) event(job, host string) *notify.Event {
+	status, code := s.Status(), s.ExitCode()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event := &notify.Event{
+		Job:      job,
+		Host:     host,
+		Action:   s.action,
+		Status:   status,
+		ExitCode: code,
+		Scanned:  s.scanned,
+		Done:     s.transferred,
+		Skipped:  s.skipped,
+		Failed:   len(s.failed),
+		Bytes:    s.bytes,
+		Start:    s.start,
+		Duration: time.Since(s.start).Round(time.Millisecond),
+	}
+
+	if s.aborted {
+		event.Errors = append(event.Errors, "aborted: pre-hook failed")
+	}
+	if s.interrupted {
+		event.Errors = append(event.Errors, "interrupted: not all files are processed")
+	}
+	for i, r := range s.failed {
+		if i == maxNotifyErrors {
+			event.Errors = append(event.Errors, fmt.Sprintf("... and %d more", len(s.failed)-maxNotifyErrors))
+			break
+		}
+		event.Errors = append(event.Errors, fmt.Sprintf("%s: %s", r.Filename, r.Err))
+	}
+
+	return event
+}
@@ -0,0 +1,279 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/storage"
+	"oss-backup/pkg/utils"
+	"sync"
+	"time"
+)
+
+const defaultPackSize = 32 << 20
+
+// packer bundles the small files of backup into pack objects, the packed
+// files are skipped by the index of packs instead of checking each object.
+type packer struct {
+	threshold int64
+	size      int64
+
+	mu      sync.Mutex
+	index   packIndex
+	pack    *storage.Pack
+	pending []packedResult
+}
+
+// packIndex is the newest pack of each packed file by key
+type packIndex map[string]packedFile
+
+type packedFile struct {
+	pack     string
+	metadata storage.Metadata
+}
+
+// Add records the file in pack, the pack keys are sorted by the creation
+func (pi packIndex) Add(item *storage.Item) {
+	if f, ok := pi[item.ObjectKey]; !ok || f.pack <= item.Pack.Object {
+		pi[item.ObjectKey] = packedFile{pack: item.Pack.Object, metadata: item.Metadata}
+	}
+}
+
+// packedResult is reported once the pack of file is uploaded
+type packedResult struct {
+	result
+	size  int64
+	entry *storage.PackEntry
+	start time.Time
+}
+
+// newPacker returns nil if the packing is not enabled by job
+func newPacker(job *conf.Job) (*packer, error) {
+	if job.PackThreshold == "" {
+		return nil, nil
+	}
+
+	threshold, err := utils.ParseBytes(job.PackThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &packer{threshold: threshold, size: defaultPackSize}
+	if job.PackSize != "" {
+		if p.size, err = utils.ParseBytes(job.PackSize); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.size <= 0 {
+		return nil, fmt.Errorf("invalid pack size %q", job.PackSize)
+	}
+	return p, nil
+}
+
+// Accepts reports whether the file of size should be packed
+func (p *packer) Accepts(size int64) bool {
+	return p != nil && size >= 0 && size < p.threshold
+}
+
+// lookup returns the metadata of file in the newest pack
+func (p *packer) lookup(key string) (storage.Metadata, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f, ok := p.index[key]
+	return f.metadata, ok
+}
+
+// add appends the file to the current pack, the pack is returned with the
+// results of files in it once it's full.
+func (p *packer) add(entry *storage.PackEntry, data []byte, pr packedResult) (*storage.Pack, []packedResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pack == nil {
+		p.pack = storage.NewPack()
+	}
+
+	p.pack.Add(entry, data)
+	p.pending = append(p.pending, pr)
+	if p.pack.Size() < p.size {
+		return nil, nil
+	}
+	return p.take()
+}
+
+// take returns the current pack and the results of files in it
+func (p *packer) take() (*storage.Pack, []packedResult) {
+	pack, pending := p.pack, p.pending
+	p.pack, p.pending = nil, nil
+	return pack, pending
+}
+
+// loadPackIndex lists the packs under the prefix of job, it's loaded only
+// once and updated by the uploaded packs.
+func (b *backupJob) loadPackIndex(ctx context.Context) error {
+	if b.packer.index != nil {
+		return nil
+	}
+
+	index := make(packIndex)
+	packs, wait := b.uploader.ListObject(ctx, storage.ObjectKey(b.prefix, storage.PackDir))
+
+	var err error
+	for pack := range packs {
+		if err != nil || pack.Metadata.Kind() != storage.KindPack {
+			continue
+		}
+
+		var items []*storage.Item
+		if items, err = storage.ReadPackIndex(ctx, b.uploader, pack, b.aes.ProxyWriter); err == nil {
+			for _, item := range items {
+				index.Add(item)
+			}
+		}
+	}
+
+	if werr := wait(); err == nil {
+		err = werr
+	}
+	if err != nil {
+		return fmt.Errorf("load index of packs: %w", err)
+	}
+
+	b.packer.mu.Lock()
+	b.packer.index = index
+	b.packer.mu.Unlock()
+	return nil
+}
+
+// uploadPacked adds the file into pack, the results are tracked once the
+// pack is uploaded.
+func (b *backupJob) uploadPacked(ctx context.Context, filename string, size int64, track func(result, int64) error) error {
+	start := time.Now()
+	r := result{Filename: filename, Status: resultFailed}
+	name, err := b.namer.Name(filename)
+	if err != nil {
+		r.Err, r.Duration = err, time.Since(start)
+		return track(r, size)
+	}
+
+	key := storage.ObjectKey(b.prefix, b.namer.Key(name))
+	r.Key = key
+
+	stat, err := os.Stat(filename)
+	if err != nil {
+		r.Err, r.Duration = fmt.Errorf("unable to stat file %s: %w", filename, err), time.Since(start)
+		return track(r, size)
+	}
+
+	if md, ok := b.packer.lookup(key); ok {
+		unchanged, _, err := b.unchanged(filename, stat, md)
+		if err != nil {
+			r.Err, r.Duration = fmt.Errorf("compare file %s: %w", filename, err), time.Since(start)
+			return track(r, size)
+		}
+
+		if unchanged {
+			r.Status, r.Duration = resultSkipped, time.Since(start)
+			return track(r, size)
+		}
+	}
+
+	// the file uploaded as an object before, e.g. it was larger than the
+	// threshold, is kept as an object so that it's not stored twice
+	exists, err := b.uploader.Exists(ctx, b.namer.Key(name))
+	if err != nil {
+		r.Err, r.Duration = fmt.Errorf("check object of file %s: %w", filename, err), time.Since(start)
+		return track(r, size)
+	}
+	if exists {
+		r = b.upload(ctx, filename)
+		r.Duration = time.Since(start)
+		return track(r, size)
+	}
+
+	fp, err := os.Open(filename)
+	if err != nil {
+		r.Err, r.Duration = fmt.Errorf("cannot open file %s: %w", filename, err), time.Since(start)
+		return track(r, size)
+	}
+
+	h := sha256.New()
+	counter := &countingReader{source: io.TeeReader(fp, h)}
+	data, err := ioutil.ReadAll(b.aes.ProxyReader(counter))
+	_ = fp.Close()
+	if err != nil {
+		r.Err, r.Duration = fmt.Errorf("read file %s: %w", filename, err), time.Since(start)
+		return track(r, size)
+	}
+
+	var checksum string
+	if b.job.CheckContent {
+		checksum = hex.EncodeToString(h.Sum(nil))
+	}
+
+	entry := &storage.PackEntry{
+		Key:      key,
+		Filename: name,
+		FileSize: counter.n,
+		ModTime:  stat.ModTime().Unix(),
+		Metadata: b.fileMetadata(stat, checksum),
+	}
+	r.Bytes = counter.n
+	if pack, pending := b.packer.add(entry, data, packedResult{result: r, size: size, entry: entry, start: start}); pack != nil {
+		return b.uploadPack(ctx, pack, pending, track)
+	}
+	return nil
+}
+
+// flushPack uploads the pack which is not full at the end of run
+func (b *backupJob) flushPack(ctx context.Context, track func(result, int64) error) error {
+	b.packer.mu.Lock()
+	pack, pending := b.packer.take()
+	b.packer.mu.Unlock()
+
+	if pack == nil {
+		return nil
+	}
+	return b.uploadPack(ctx, pack, pending, track)
+}
+
+func (b *backupJob) uploadPack(ctx context.Context, pack *storage.Pack, pending []packedResult, track func(result, int64) error) error {
+	data, md, err := pack.Seal(b.aes.ProxyReader)
+	if err == nil {
+		item := &storage.Item{Filename: pack.Key(), ObjectKey: pack.Key(), FileSize: data.Size()}
+		err = b.uploader.Upload(ctx, item, storage.RewindReader(data, func(r io.Reader) io.Reader {
+			return b.bw.Reader(ctx, r)
+		}), md)
+	}
+
+	key := storage.ObjectKey(b.prefix, pack.Key())
+	if err == nil {
+		b.log.Debug("pack uploaded", "key", key, "files", pack.Len(), "bytes", data.Size())
+
+		b.packer.mu.Lock()
+		for _, pr := range pending {
+			b.packer.index.Add(pr.entry.Item(key))
+		}
+		b.packer.mu.Unlock()
+	}
+
+	for _, pr := range pending {
+		r := pr.result
+		if err != nil {
+			r.Bytes, r.Err = 0, fmt.Errorf("upload pack %s of file %s failed, cause by %w", key, r.Filename, err)
+		} else {
+			r.Status = resultTransferred
+		}
+
+		r.Duration = time.Since(pr.start)
+		_ = track(r, pr.size)
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"oss-backup/pkg/limiter"
+	"oss-backup/pkg/logger"
+	"syscall"
+)
+
+// handleInterrupt shuts down the pool on the first interrupt so that the
+// in-flight jobs can be finished, and aborts them on the second one.
+func handleInterrupt(pool *limiter.Pool) (stop func()) {
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+
+	done := make(chan struct{})
+	go func() {
+		for interrupted := false; ; interrupted = true {
+			select {
+			case <-done:
+				return
+			case <-ch:
+			}
+
+			if !interrupted {
+				logger.Info("interrupted, waiting for in-flight jobs, interrupt again to abort")
+				pool.Shutdown()
+			} else {
+				logger.Warn("aborting in-flight jobs")
+				pool.Abort()
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
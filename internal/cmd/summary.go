@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/progress"
+	"oss-backup/pkg/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exit codes of the commands, logger.Fatal exits with ExitFailure
+const (
+	ExitOK             = 0
+	ExitFailure        = 1
+	ExitConfigError    = 2
+	ExitPartialFailure = 3
+	ExitTotalFailure   = 4
+	ExitDifferences    = 5
+	ExitHookFailure    = 6
+)
+
+// Exit flushes the logs, e.g. to the log file, and exits with the code
+func Exit(code int) {
+	_ = logger.Sync()
+	os.Exit(code)
+}
+
+// FatalConfig is equivalent to logger.Error followed by exit with ExitConfigError
+func FatalConfig(v ...interface{}) {
+	logger.Error(fmt.Sprint(v...))
+	Exit(ExitConfigError)
+}
+
+type resultStatus int
+
+const (
+	resultTransferred resultStatus = iota
+	resultSkipped
+	resultFailed
+)
+
+// result is the outcome of transferring a single file
+type result struct {
+	Filename string
+	Key      string
+	Status   resultStatus
+	Bytes    int64
+	Duration time.Duration
+	Err      error
+}
+
+// logResult logs the result of action, the skipped files are only logged
+// in debug level
+func logResult(log *logger.Logger, action string, r result) {
+	kv := []interface{}{"file", r.Filename, "key", r.Key}
+	switch r.Status {
+	case resultTransferred:
+		log.Info("file "+action, append(kv, "bytes", r.Bytes, "duration", r.Duration)...)
+	case resultSkipped:
+		log.Debug("file skipped", kv...)
+	case resultFailed:
+		log.Error("file failed", append(kv, "duration", r.Duration, "error", r.Err)...)
+	}
+}
+
+// summary collects the results of all files in a run
+type summary struct {
+	mu          sync.Mutex
+	action      string
+	start       time.Time
+	scanned     int
+	transferred int
+	skipped     int
+	failed      []result
+	bytes       int64
+	interrupted bool
+	aborted     bool
+}
+
+// startProgress tracks the transfers of ctx, the logs are printed above the
+// live display until stopped
+func startProgress(ctx context.Context, action string) (context.Context, *progress.Tracker, func()) {
+	tracker := progress.New(os.Stderr, action)
+	if !tracker.Live() || logger.Default().Output() != io.Writer(os.Stderr) {
+		return progress.NewContext(ctx, tracker), tracker, tracker.Stop
+	}
+
+	logger.Default().SetOutput(tracker)
+	return progress.NewContext(ctx, tracker), tracker, func() {
+		logger.Default().SetOutput(os.Stderr)
+		tracker.Stop()
+	}
+}
+
+func newSummary(action string) *summary {
+	return &summary{action: action, start: time.Now()}
+}
+
+func (s *summary) Scan() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scanned++
+}
+
+func (s *summary) Interrupt() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.interrupted = true
+}
+
+// Abort marks the run aborted by pre-hook
+func (s *summary) Abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.aborted = true
+}
+
+func (s *summary) Add(r result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Status {
+	case resultTransferred:
+		s.transferred++
+		s.bytes += r.Bytes
+	case resultSkipped:
+		s.skipped++
+	case resultFailed:
+		s.failed = append(s.failed, r)
+	}
+}
+
+func (s *summary) ExitCode() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.aborted:
+		return ExitHookFailure
+	case len(s.failed) == 0 && !s.interrupted:
+		return ExitOK
+	case len(s.failed) != 0 && s.transferred == 0 && s.skipped == 0:
+		return ExitTotalFailure
+	default:
+		return ExitPartialFailure
+	}
+}
+
+// Status returns the result of run described by exit code
+func (s *summary) Status() string {
+	switch s.ExitCode() {
+	case ExitOK:
+		return "success"
+	case ExitPartialFailure:
+		return "partial"
+	case ExitHookFailure:
+		return "aborted"
+	default:
+		return "failure"
+	}
+}
+
+// Env returns the summary as environment variables for hooks
+func (s *summary) Env() []string {
+	status, code := s.Status(), s.ExitCode()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return []string{
+		"OSS_BACKUP_STATUS=" + status,
+		"OSS_BACKUP_EXIT_CODE=" + strconv.Itoa(code),
+		"OSS_BACKUP_FILES_SCANNED=" + strconv.Itoa(s.scanned),
+		"OSS_BACKUP_FILES_" + strings.ToUpper(s.action) + "=" + strconv.Itoa(s.transferred),
+		"OSS_BACKUP_FILES_SKIPPED=" + strconv.Itoa(s.skipped),
+		"OSS_BACKUP_FILES_FAILED=" + strconv.Itoa(len(s.failed)),
+		"OSS_BACKUP_BYTES=" + strconv.FormatInt(s.bytes, 10),
+		"OSS_BACKUP_DURATION=" + strconv.FormatFloat(time.Since(s.start).Seconds(), 'f', 3, 64),
+	}
+}
+
+func (s *summary) Print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := time.Since(s.start)
+	throughput := float64(s.bytes) / elapsed.Seconds()
+
+	_, _ = fmt.Fprintf(w, "files: %d scanned, %d %s, %d skipped, %d failed\n",
+		s.scanned, s.transferred, s.action, s.skipped, len(s.failed))
+	_, _ = fmt.Fprintf(w, "bytes: %s %s in %s (%s/s)\n", utils.FormatBytes(s.bytes), s.action,
+		elapsed.Round(time.Millisecond), utils.FormatBytes(int64(throughput)))
+	if s.interrupted {
+		_, _ = fmt.Fprintln(w, "interrupted: not all files are processed")
+	}
+	if s.aborted {
+		_, _ = fmt.Fprintln(w, "aborted: pre-hook failed")
+	}
+
+	for _, r := range s.failed {
+		_, _ = fmt.Fprintf(w, "failed: %s: %s\n", r.Filename, r.Err)
+	}
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"oss-backup/pkg/storage"
+	"oss-backup/pkg/utils"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// dirNode is a directory reconstructed from the decrypted filenames, the
+// files and size are counted recursively.
+type dirNode struct {
+	name     string
+	files    int
+	size     int64
+	children map[string]*dirNode
+	items    []*storage.Item
+}
+
+func newDirNode(name string) *dirNode {
+	return &dirNode{name: name, children: make(map[string]*dirNode)}
+}
+
+// splitPath splits the filename into directories and the basename, both
+// slash and backslash are treated as separator.
+func splitPath(filename string) ([]string, string) {
+	var parts []string
+	for _, part := range strings.Split(strings.ReplaceAll(filename, "\\", "/"), "/") {
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil, filename
+	}
+	return parts[:len(parts)-1], parts[len(parts)-1]
+}
+
+func (n *dirNode) Add(item *storage.Item) {
+	dirs, _ := splitPath(item.Filename)
+
+	node := n
+	node.files++
+	node.size += item.FileSize
+	for _, dir := range dirs {
+		child, ok := node.children[dir]
+		if !ok {
+			child = newDirNode(dir)
+			node.children[dir] = child
+		}
+
+		node = child
+		node.files++
+		node.size += item.FileSize
+	}
+	node.items = append(node.items, item)
+}
+
+func (n *dirNode) sortedChildren() []*dirNode {
+	children := make([]*dirNode, 0, len(n.children))
+	for _, child := range n.children {
+		children = append(children, child)
+	}
+
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children
+}
+
+func (n *dirNode) sortedItems() []*storage.Item {
+	items := append([]*storage.Item(nil), n.items...)
+	sort.Slice(items, func(i, j int) bool { return items[i].Filename < items[j].Filename })
+	return items
+}
+
+func formatSize(n int64, exact bool) string {
+	if exact {
+		return strconv.FormatInt(n, 10)
+	}
+	return utils.FormatBytes(n)
+}
+
+// PrintTree prints the hierarchy like tree(1), the nodes deeper than depth
+// are omitted unless depth is 0.
+func (n *dirNode) PrintTree(w io.Writer, depth int, exact bool) {
+	_, _ = fmt.Fprintf(w, "/ (%d files, %s)\n", n.files, formatSize(n.size, exact))
+	n.printTree(w, "", 1, depth, exact)
+}
+
+func (n *dirNode) printTree(w io.Writer, indent string, level, depth int, exact bool) {
+	if depth > 0 && level > depth {
+		return
+	}
+
+	children, items := n.sortedChildren(), n.sortedItems()
+	for i, child := range children {
+		branch, next := "├── ", "│   "
+		if i == len(children)-1 && len(items) == 0 {
+			branch, next = "└── ", "    "
+		}
+
+		_, _ = fmt.Fprintf(w, "%s%s%s/ (%d files, %s)\n", indent, branch, child.name, child.files, formatSize(child.size, exact))
+		child.printTree(w, indent+next, level+1, depth, exact)
+	}
+
+	for i, item := range items {
+		branch := "├── "
+		if i == len(items)-1 {
+			branch = "└── "
+		}
+
+		_, name := splitPath(item.Filename)
+		_, _ = fmt.Fprintf(w, "%s%s%s (%s)\n", indent, branch, name, formatSize(item.FileSize, exact))
+	}
+}
+
+// Walk visits the directories in depth-first order with their full path
+func (n *dirNode) Walk(fn func(dir string, level int, node *dirNode)) {
+	n.walk("/", 0, fn)
+}
+
+func (n *dirNode) walk(dir string, level int, fn func(string, int, *dirNode)) {
+	fn(dir, level, n)
+	for _, child := range n.sortedChildren() {
+		child.walk(path.Join(dir, child.name), level+1, fn)
+	}
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/lock"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/storage"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func UnlockCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "unlock",
+		Short: "remove stale locks of the repository",
+		Args:  cobra.NoArgs,
+	}
+
+	cmd.PersistentFlags().BoolP("all", "", false, "remove all locks, including the ones not expired yet")
+	cmd.Run = doUnlockCommand
+
+	return cmd
+}
+
+func doUnlockCommand(cmd *cobra.Command, _ []string) {
+	cfg := cmd.Context().Value("cfg").(*conf.Config)
+
+	oss, err := storage.New(currentBucket(cfg))
+	if err != nil {
+		FatalConfig(err)
+	}
+
+	infos, err := lock.List(cmd.Context(), oss)
+	if err != nil {
+		logger.Error("list locks failed", "error", err)
+		Exit(ExitTotalFailure)
+	}
+
+	all, _ := cmd.Flags().GetBool("all")
+	now, failed := time.Now(), false
+	for _, info := range infos {
+		if !all && !info.Expired(now) {
+			logger.Info("lock is kept", "key", info.Key, "lock", info.String())
+			continue
+		}
+
+		if err := oss.Delete(cmd.Context(), info.Key); err != nil {
+			logger.Error("remove lock failed", "key", info.Key, "error", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("removed %s: %s\n", info.Key, info)
+	}
+
+	if failed {
+		Exit(ExitPartialFailure)
+	}
+}
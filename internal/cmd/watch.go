@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// maxBatchDelay is the multiple of debounce a batch can be delayed by the
+// files which are written continuously
+const maxBatchDelay = 10
+
+// watchBatch is the changed files uploaded by the worker of Watch, all the
+// paths are walked again if full is set.
+type watchBatch struct {
+	filenames []string
+	full      bool
+}
+
+// Watch runs the backup once and then uploads the changed files in batches,
+// a batch is flushed once no events received in the debounce period. The
+// batches are uploaded by a worker, so that the events are kept draining
+// during a long batch. It returns the exit code after the watching stopped
+// by a signal.
+func (b *backupJob) Watch(ctx context.Context, debounce time.Duration) int {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		b.log.Error("unable to create watcher", "error", err)
+		return ExitFailure
+	}
+	defer func() { _ = watcher.Close() }()
+
+	// watching before the first run, so that the changes during it are not lost
+	for _, path := range b.job.Paths {
+		b.watchTree(watcher, path)
+	}
+
+	stopping := make(chan os.Signal, 1)
+	signal.Notify(stopping, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stopping)
+
+	failed := false
+	batches, results := make(chan watchBatch, 1), make(chan bool, 1)
+	go func() {
+		defer close(results)
+
+		results <- b.Run(ctx).ExitCode() == ExitOK
+		for batch := range batches {
+			results <- b.uploadBatch(ctx, batch).ExitCode() == ExitOK
+		}
+	}()
+
+	timer := time.NewTimer(debounce)
+	stopTimer(timer)
+
+	// the first run is busy until its result is received
+	busy, ready, rescan := true, false, false
+	var first time.Time
+	pending := make(map[string]struct{})
+	dispatch := func() {
+		batch := watchBatch{full: rescan}
+		if !rescan {
+			batch.filenames = make([]string, 0, len(pending))
+			for filename := range pending {
+				batch.filenames = append(batch.filenames, filename)
+			}
+			sort.Strings(batch.filenames)
+		}
+
+		batches <- batch
+		busy, ready, rescan = true, false, false
+		pending, first = make(map[string]struct{}), time.Time{}
+	}
+
+	b.log.Info("watching for changes", "paths", len(b.job.Paths))
+	for {
+		select {
+		case <-stopping:
+			b.log.Info("stop watching", "dropped", len(pending))
+			close(batches)
+			for ok := range results {
+				failed = failed || !ok
+			}
+
+			if failed {
+				return ExitPartialFailure
+			}
+			return ExitOK
+		case ok := <-results:
+			failed, busy = failed || !ok, false
+			if ready {
+				dispatch()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				continue
+			}
+
+			if err != fsnotify.ErrEventOverflow {
+				b.log.Error("watcher error", "error", err)
+				continue
+			}
+
+			// the changes are lost, walk all the paths in next batch
+			b.log.Warn("watcher queue overflowed, rescanning all paths")
+			for _, path := range b.job.Paths {
+				b.watchTree(watcher, path)
+			}
+			rescan = true
+			stopTimer(timer)
+			timer.Reset(debounce)
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return ExitFailure
+			}
+
+			// removed files are kept in the remote
+			if ev.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+
+			stat, err := os.Stat(ev.Name)
+			if err != nil {
+				continue
+			}
+
+			if stat.IsDir() {
+				// files may be created before the directory is watched
+				b.watchTree(watcher, ev.Name)
+				for filename := range walk(ctx, []string{ev.Name}, nil) {
+					pending[filename] = struct{}{}
+				}
+			} else {
+				pending[ev.Name] = struct{}{}
+			}
+
+			if len(pending) == 0 {
+				continue
+			}
+
+			if first.IsZero() {
+				first = time.Now()
+			}
+
+			if time.Since(first) < maxBatchDelay*debounce {
+				stopTimer(timer)
+				timer.Reset(debounce)
+			}
+		case <-timer.C:
+			// the batch waits for the running one, and takes the changes
+			// happened in the meantime
+			if busy {
+				ready = true
+			} else {
+				dispatch()
+			}
+		}
+	}
+}
+
+func (b *backupJob) uploadBatch(ctx context.Context, batch watchBatch) *summary {
+	sum := newSummary("uploaded")
+	if batch.full {
+		b.log.Info("rescanning all paths")
+		b.transfer(ctx, sum, func(ctx context.Context, fail func(string, error)) <-chan string {
+			return walk(ctx, b.job.Paths, fail)
+		})
+	} else {
+		b.log.Info("files changed, uploading", "files", len(batch.filenames))
+		b.transfer(ctx, sum, func(ctx context.Context, _ func(string, error)) <-chan string {
+			files := make(chan string)
+			go func() {
+				defer close(files)
+				for _, filename := range batch.filenames {
+					// temporary files of editors may be removed before the
+					// batch, which are not failures
+					if _, err := os.Lstat(filename); os.IsNotExist(err) {
+						continue
+					}
+
+					select {
+					case files <- filename:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}()
+
+			return files
+		})
+	}
+
+	sum.Print(os.Stderr)
+	b.record(sum)
+	b.notify(sum)
+	return sum
+}
+
+// watchTree adds the directory and all of its subdirectories to the watcher,
+// a single file is watched by itself.
+func (b *backupJob) watchTree(watcher *fsnotify.Watcher, path string) {
+	_ = filepath.Walk(path, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if info.IsDir() || filename == path {
+			if err := watcher.Add(filename); err != nil {
+				// usually the limit of fs.inotify.max_user_watches is reached
+				b.log.Error("unable to watch", "file", filename, "error", err)
+			}
+		}
+		return nil
+	})
+}
+
+func stopTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+}
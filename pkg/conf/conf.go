@@ -14,9 +14,19 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"oss-backup/pkg/utils"
+	"strconv"
 	"strings"
 )
 
+const (
+	BucketTypeOss = "oss"
+	BucketTypeS3  = "s3"
+
+	KeyModeMd5  = "md5"
+	KeyModeHmac = "hmac"
+)
+
 type Bucket struct {
 	Alias           string `json:"alias"`
 	Endpoint        string `json:"endpoint"`
@@ -25,16 +35,56 @@ type Bucket struct {
 	BucketName      string `json:"bucket_name"`
 	RsaPrivateKey   string `json:"rsa_private"`
 
+	// credentials used when access key absent, see package credentials
+	CredentialsFile string `json:"credentials_file,omitempty"`
+	RoleArn         string `json:"role_arn,omitempty"`
+	RoleSessionName string `json:"role_session_name,omitempty"`
+	StsEndpoint     string `json:"sts_endpoint,omitempty"`
+	EcsRamRole      string `json:"ecs_ram_role,omitempty"`
+
+	Retry *Retry `json:"retry,omitempty"`
+	// KeyMode is how object keys are derived from filenames, md5 or hmac
+	KeyMode string `json:"key_mode,omitempty"`
+	// HostNamespace puts the objects of each host under the directory of host
+	HostNamespace bool `json:"host_namespace,omitempty"`
+	// Type is the kind of storage, oss by default or s3 for the services
+	// compatible with S3, e.g. MinIO
+	Type string `json:"type,omitempty"`
+	// Region is signed in the requests of s3, us-east-1 by default
+	Region string `json:"region,omitempty"`
+
 	ObjectPrefix string `json:"-"`
 }
 
+// Retry overrides the policy of retrying transient errors, the zero fields
+// use the defaults of package storage
+type Retry struct {
+	Attempts   int      `json:"attempts,omitempty"`
+	Backoff    string   `json:"backoff,omitempty"`
+	MaxBackoff string   `json:"max_backoff,omitempty"`
+	Jitter     *float64 `json:"jitter,omitempty"`
+}
+
 func (b *Bucket) Wizard() error {
-	prompt("Endpoint: ", &b.Endpoint)
-	prompt("AccessKeyId: ", &b.AccessKeyId)
-	prompt("AccessKeySecret: ", &b.AccessKeySecret)
-	prompt("BucketName: ", &b.BucketName)
-	prompt("Alias: ", &b.Alias)
+	for _, field := range []struct {
+		text string
+		v    *string
+	}{
+		{"Endpoint: ", &b.Endpoint},
+		{"AccessKeyId: ", &b.AccessKeyId},
+		{"AccessKeySecret: ", &b.AccessKeySecret},
+		{"BucketName: ", &b.BucketName},
+		{"Alias: ", &b.Alias},
+	} {
+		if err := prompt(field.text, field.v); err != nil {
+			return err
+		}
+	}
+
+	return b.ensureRsaPrivateKey()
+}
 
+func (b *Bucket) ensureRsaPrivateKey() error {
 	if b.RsaPrivateKey == "" {
 		k, err := rsa.GenerateKey(rand.Reader, rsaBitsSize)
 		if err != nil {
@@ -50,6 +100,134 @@ func (b *Bucket) Wizard() error {
 	return nil
 }
 
+func (b *Bucket) Validate() error {
+	switch "" {
+	case b.Alias:
+		return errors.New("alias of bucket is required")
+	case b.Endpoint:
+		return errors.New("endpoint of bucket is required")
+	case b.BucketName:
+		return errors.New("name of bucket is required")
+	}
+
+	if (b.AccessKeyId == "") != (b.AccessKeySecret == "") {
+		return errors.New("both access key id and secret are required")
+	}
+
+	switch b.Type {
+	case "", BucketTypeOss, BucketTypeS3:
+	default:
+		return fmt.Errorf("unknown type %q of bucket, oss or s3 is supported", b.Type)
+	}
+	return validateKeyMode(b.KeyMode)
+}
+
+func validateKeyMode(mode string) error {
+	switch mode {
+	case "", KeyModeMd5, KeyModeHmac:
+		return nil
+	default:
+		return fmt.Errorf("unknown key_mode %q, md5 or hmac is supported", mode)
+	}
+}
+
+func (b *Bucket) fields() map[string]*string {
+	return map[string]*string{
+		"endpoint":          &b.Endpoint,
+		"bucket_name":       &b.BucketName,
+		"access_key_id":     &b.AccessKeyId,
+		"access_key_secret": &b.AccessKeySecret,
+		"credentials_file":  &b.CredentialsFile,
+		"role_arn":          &b.RoleArn,
+		"role_session_name": &b.RoleSessionName,
+		"sts_endpoint":      &b.StsEndpoint,
+		"ecs_ram_role":      &b.EcsRamRole,
+		"type":              &b.Type,
+		"region":            &b.Region,
+		"key_mode":          &b.KeyMode,
+	}
+}
+
+// Set updates the field of bucket by its configure key, the special key
+// access_key_secret_file reads the secret from file instead of command line.
+// The fields of retry policy are set by the keys with prefix retry_.
+func (b *Bucket) Set(key, value string) error {
+	switch key {
+	case "access_key_secret_file":
+		secret, err := ReadSecretFile(value)
+		if err != nil {
+			return err
+		}
+		key, value = "access_key_secret", secret
+	case "retry_attempts", "retry_backoff", "retry_max_backoff", "retry_jitter":
+		return b.setRetry(key, value)
+	case "key_mode":
+		if err := validateKeyMode(value); err != nil {
+			return err
+		}
+	case "host_namespace":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid host_namespace %q: %w", value, err)
+		}
+		b.HostNamespace = enabled
+		return nil
+	}
+
+	field, ok := b.fields()[key]
+	if !ok {
+		return fmt.Errorf("unknown bucket key %q", key)
+	}
+
+	*field = value
+	return nil
+}
+
+// setRetry updates the field of retry policy, the empty value restores the
+// default of package storage.
+func (b *Bucket) setRetry(key, value string) error {
+	retry := Retry{}
+	if b.Retry != nil {
+		retry = *b.Retry
+	}
+
+	var err error
+	switch key {
+	case "retry_attempts":
+		retry.Attempts = 0
+		if value != "" {
+			retry.Attempts, err = strconv.Atoi(value)
+		}
+	case "retry_backoff":
+		retry.Backoff = value
+		if value != "" {
+			_, err = utils.ParseDuration(value)
+		}
+	case "retry_max_backoff":
+		retry.MaxBackoff = value
+		if value != "" {
+			_, err = utils.ParseDuration(value)
+		}
+	case "retry_jitter":
+		retry.Jitter = nil
+		if value != "" {
+			var jitter float64
+			if jitter, err = strconv.ParseFloat(value, 64); err == nil {
+				retry.Jitter = &jitter
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+
+	b.Retry = &retry
+	if retry == (Retry{}) {
+		b.Retry = nil
+	}
+	return nil
+}
+
 func (b *Bucket) DumpRsaPrivateKey(w io.Writer) error {
 	bs, err := base64.StdEncoding.DecodeString(b.RsaPrivateKey)
 	if err != nil {
@@ -114,31 +292,148 @@ func (bs Buckets) Find(name string) *Bucket {
 	return nil
 }
 
+// Job is a backup scheduled by daemon, the fields are same as the flags of
+// backup command.
+type Job struct {
+	Name           string   `json:"name"`
+	Schedule       string   `json:"schedule"`
+	Bucket         string   `json:"bucket,omitempty"`
+	Paths          []string `json:"paths"`
+	Prefix         string   `json:"prefix,omitempty"`
+	Password       string   `json:"password,omitempty"`
+	PasswordFile   string   `json:"password_file,omitempty"`
+	MaxConcurrency int      `json:"max_concurrency,omitempty"`
+	LimitUpload    string   `json:"limit_upload,omitempty"`
+	FailFast       bool     `json:"fail_fast,omitempty"`
+	PreHook        string   `json:"pre_hook,omitempty"`
+	PostHook       string   `json:"post_hook,omitempty"`
+	Retries        int      `json:"retries,omitempty"`
+	RetryDelay     string   `json:"retry_delay,omitempty"`
+	Host           string   `json:"host,omitempty"`
+	Root           string   `json:"root,omitempty"`
+	CheckContent   bool     `json:"check_content,omitempty"`
+	PackThreshold  string   `json:"pack_threshold,omitempty"`
+	PackSize       string   `json:"pack_size,omitempty"`
+}
+
+// GetPassword returns the password of job, which can be read from file
+func (j *Job) GetPassword() (string, error) {
+	if j.Password == "" && j.PasswordFile != "" {
+		return ReadSecretFile(j.PasswordFile)
+	}
+	return j.Password, nil
+}
+
+type Jobs []*Job
+
+func (js Jobs) Find(name string) *Job {
+	for _, job := range js {
+		if job.Name == name {
+			return job
+		}
+	}
+
+	return nil
+}
+
+// Notification is a sink of the summary of runs, the type is one of webhook,
+// slack, dingtalk and email
+type Notification struct {
+	Name     string            `json:"name,omitempty"`
+	Type     string            `json:"type"`
+	On       string            `json:"on,omitempty"`
+	Template string            `json:"template,omitempty"`
+	URL      string            `json:"url,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+
+	SmtpAddr     string   `json:"smtp_addr,omitempty"`
+	SmtpTls      bool     `json:"smtp_tls,omitempty"`
+	Username     string   `json:"username,omitempty"`
+	Password     string   `json:"password,omitempty"`
+	PasswordFile string   `json:"password_file,omitempty"`
+	From         string   `json:"from,omitempty"`
+	To           []string `json:"to,omitempty"`
+	Subject      string   `json:"subject,omitempty"`
+}
+
+// GetPassword returns the smtp password, which can be read from file
+func (n *Notification) GetPassword() (string, error) {
+	if n.Password == "" && n.PasswordFile != "" {
+		return ReadSecretFile(n.PasswordFile)
+	}
+	return n.Password, nil
+}
+
 type Config struct {
-	Filename      string  `json:"-"`
-	Buckets       Buckets `json:"buckets"`
-	DefaultBucket string  `json:"default_bucket"`
+	Filename      string          `json:"-"`
+	Buckets       Buckets         `json:"buckets"`
+	DefaultBucket string          `json:"default_bucket"`
+	Jobs          Jobs            `json:"jobs,omitempty"`
+	Notifications []*Notification `json:"notifications,omitempty"`
 }
 
 const (
 	rsaBitsSize = 2048
 )
 
+func (c *Config) Load(filename string) error {
+	c.Filename = filename
+	if !utils.Exists(filename) {
+		return nil
+	}
+
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if len(bs) != 0 {
+		return json.Unmarshal(bs, c)
+	}
+	return nil
+}
+
 func (c *Config) NewBucket() error {
-	var bucket Bucket
+	// the hosts sharing a new bucket don't overwrite each other by default
+	bucket := Bucket{HostNamespace: true}
 	if err := bucket.Wizard(); err != nil {
 		return err
 	}
 
-	if c.Buckets.Find(bucket.Alias) != nil || c.Buckets.Find(bucket.BucketName) != nil {
-		return errors.New("duplicated bucket name or alias")
+	if err := c.AddBucket(&bucket); err != nil {
+		return err
 	}
 
 	c.DefaultBucket = bucket.Alias
-	c.Buckets = append(c.Buckets, &bucket)
 	return c.Save()
 }
 
+// AddBucket appends the bucket into configure without prompting, the rsa key
+// will be generated if absent.
+func (c *Config) AddBucket(bucket *Bucket) error {
+	if bucket.Alias == "" {
+		bucket.Alias = bucket.BucketName
+	}
+
+	if err := bucket.Validate(); err != nil {
+		return err
+	}
+
+	if c.Buckets.Find(bucket.Alias) != nil || c.Buckets.Find(bucket.BucketName) != nil {
+		return errors.New("duplicated bucket name or alias")
+	}
+
+	if err := bucket.ensureRsaPrivateKey(); err != nil {
+		return err
+	}
+
+	if c.DefaultBucket == "" {
+		c.DefaultBucket = bucket.Alias
+	}
+	c.Buckets = append(c.Buckets, bucket)
+	return nil
+}
+
 func (c *Config) FindBucket(name string) *Bucket {
 	return c.Buckets.Find(name)
 }
@@ -157,6 +452,68 @@ func (c *Config) RemoveBucket(name string) {
 	}
 }
 
+func (c *Config) RenameBucket(name, alias string) error {
+	bucket := c.Buckets.Find(name)
+	if bucket == nil {
+		return errors.New("unknown bucket name")
+	}
+
+	if other := c.Buckets.Find(alias); other != nil && other != bucket {
+		return errors.New("duplicated bucket name or alias")
+	}
+
+	if bucket.NameIs(c.DefaultBucket) {
+		c.DefaultBucket = alias
+	}
+	bucket.Alias = alias
+	return nil
+}
+
+// Import merges the buckets from an exported configure, the existing bucket
+// with same alias is replaced only if overwrite is set.
+func (c *Config) Import(r io.Reader, overwrite bool) (int, error) {
+	var imported Config
+	if err := json.NewDecoder(r).Decode(&imported); err != nil {
+		return 0, err
+	}
+
+	for _, bucket := range imported.Buckets {
+		if overwrite && bucket.Alias != "" {
+			c.RemoveBucket(bucket.Alias)
+		}
+
+		if err := c.AddBucket(bucket); err != nil {
+			return 0, fmt.Errorf("import bucket %s: %w", bucket.Alias, err)
+		}
+	}
+
+	if c.FindBucket(c.DefaultBucket) == nil && imported.DefaultBucket != "" {
+		c.DefaultBucket = imported.DefaultBucket
+	}
+	return len(imported.Buckets), nil
+}
+
+// Export writes the named buckets (or all of them) as a configure which can
+// be imported by another host.
+func (c *Config) Export(w io.Writer, names ...string) error {
+	exported := Config{Buckets: c.Buckets, DefaultBucket: c.DefaultBucket}
+	if len(names) != 0 {
+		exported.Buckets = nil
+		for _, name := range names {
+			bucket := c.Buckets.Find(name)
+			if bucket == nil {
+				return fmt.Errorf("unknown bucket name %s", name)
+			}
+			exported.Buckets = append(exported.Buckets, bucket)
+		}
+		exported.DefaultBucket = exported.Buckets[0].Alias
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&exported)
+}
+
 func (c *Config) UseBucket(name string) error {
 	if c.Buckets.Find(name) == nil {
 		return errors.New("unknown bucket name")
@@ -184,16 +541,37 @@ func (c *Config) GetBucket() *Bucket {
 	return bucket
 }
 
-func prompt(text string, v *string) {
-	scanner := bufio.NewScanner(os.Stdin)
+// ReadSecretFile reads a secret from file, "-" means reading from stdin.
+func ReadSecretFile(filename string) (string, error) {
+	var bs []byte
+	var err error
+	if filename == "-" {
+		bs, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		bs, err = ioutil.ReadFile(filename)
+	}
 
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bs)), nil
+}
+
+var stdin = bufio.NewScanner(os.Stdin)
+
+func prompt(text string, v *string) error {
 	for {
 		fmt.Print(text)
-		if scanner.Scan() {
-			if s := scanner.Text(); strings.TrimSpace(s) != "" {
-				*v = s
-				break
+		if !stdin.Scan() {
+			if err := stdin.Err(); err != nil {
+				return err
 			}
+			return io.ErrUnexpectedEOF
+		}
+
+		if s := stdin.Text(); strings.TrimSpace(s) != "" {
+			*v = s
+			return nil
 		}
 	}
 }
@@ -0,0 +1,179 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials is an access key pair with an optional security token, the
+// temporary one is expired at Expiration.
+type Credentials struct {
+	AccessKeyId     string    `json:"access_key_id"`
+	AccessKeySecret string    `json:"access_key_secret"`
+	SecurityToken   string    `json:"security_token"`
+	Expiration      time.Time `json:"expiration"`
+}
+
+func (c *Credentials) GetAccessKeyID() string {
+	return c.AccessKeyId
+}
+
+func (c *Credentials) GetAccessKeySecret() string {
+	return c.AccessKeySecret
+}
+
+func (c *Credentials) GetSecurityToken() string {
+	return c.SecurityToken
+}
+
+func (c *Credentials) validate() error {
+	if c.AccessKeyId == "" || c.AccessKeySecret == "" {
+		return errors.New("access key id or secret is empty")
+	}
+	return nil
+}
+
+// expiresWithin reports whether the credentials will be expired in d, the
+// credentials without expiration never expired.
+func (c *Credentials) expiresWithin(d time.Duration) bool {
+	return !c.Expiration.IsZero() && time.Now().Add(d).After(c.Expiration)
+}
+
+type Provider interface {
+	Name() string
+	Retrieve() (*Credentials, error)
+}
+
+type staticProvider struct {
+	creds Credentials
+}
+
+func (p *staticProvider) Name() string {
+	return "static"
+}
+
+func (p *staticProvider) Retrieve() (*Credentials, error) {
+	creds := p.creds
+	return &creds, creds.validate()
+}
+
+func NewStaticProvider(accessKeyId, accessKeySecret string) Provider {
+	return &staticProvider{creds: Credentials{AccessKeyId: accessKeyId, AccessKeySecret: accessKeySecret}}
+}
+
+// chainProvider retrieves credentials from the first provider which works,
+// and keeps using it until it failed.
+type chainProvider struct {
+	providers []Provider
+	current   Provider
+}
+
+func (p *chainProvider) Name() string {
+	if p.current != nil {
+		return p.current.Name()
+	}
+	return "chain"
+}
+
+func (p *chainProvider) Retrieve() (*Credentials, error) {
+	if p.current != nil {
+		if creds, err := p.current.Retrieve(); err == nil {
+			return creds, nil
+		}
+	}
+
+	var errs []string
+	for _, provider := range p.providers {
+		creds, err := provider.Retrieve()
+		if err == nil {
+			p.current = provider
+			return creds, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", provider.Name(), err))
+	}
+
+	p.current = nil
+	return nil, fmt.Errorf("no valid credentials found (%s)", strings.Join(errs, "; "))
+}
+
+func NewChainProvider(providers ...Provider) Provider {
+	return &chainProvider{providers: providers}
+}
+
+const (
+	// refreshWindow is the duration before expiration to refresh credentials
+	refreshWindow = 5 * time.Minute
+)
+
+// refreshingProvider caches the credentials until they are about to expire,
+// it is safe for concurrent use.
+type refreshingProvider struct {
+	mu       sync.Mutex
+	provider Provider
+	cached   *Credentials
+}
+
+func (p *refreshingProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *refreshingProvider) Retrieve() (*Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != nil && !p.cached.expiresWithin(refreshWindow) {
+		return p.cached, nil
+	}
+
+	creds, err := p.provider.Retrieve()
+	if err != nil {
+		// keep using the old one until it is really expired
+		if p.cached != nil && !p.cached.expiresWithin(0) {
+			logger.Warn("refresh credentials failed", "provider", p.provider.Name(), "error", err)
+			return p.cached, nil
+		}
+		return nil, err
+	}
+
+	p.cached = creds
+	return creds, nil
+}
+
+func NewRefreshingProvider(provider Provider) Provider {
+	return &refreshingProvider{provider: provider}
+}
+
+// ForBucket creates the provider chain for the bucket, the credentials are
+// searched in order of configure, environment variables, credentials file
+// and ECS RAM role, and then used to assume the role if configured.
+func ForBucket(bucket *conf.Bucket) Provider {
+	var providers []Provider
+	if bucket.AccessKeyId != "" || bucket.AccessKeySecret != "" {
+		providers = append(providers, NewStaticProvider(bucket.AccessKeyId, bucket.AccessKeySecret))
+	}
+
+	providers = append(providers, NewEnvProvider())
+	if bucket.CredentialsFile != "" {
+		providers = append(providers, NewFileProvider(bucket.CredentialsFile))
+	}
+
+	if bucket.EcsRamRole != "" {
+		providers = append(providers, NewEcsRamRoleProvider(bucket.EcsRamRole))
+	}
+
+	provider := NewChainProvider(providers...)
+	if bucket.RoleArn != "" {
+		provider = NewAssumeRoleProvider(NewRefreshingProvider(provider), &AssumeRoleConfig{
+			Endpoint:        bucket.StsEndpoint,
+			RoleArn:         bucket.RoleArn,
+			RoleSessionName: bucket.RoleSessionName,
+		})
+	}
+
+	return NewRefreshingProvider(provider)
+}
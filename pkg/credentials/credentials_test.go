@@ -0,0 +1,251 @@
+package credentials
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type countingProvider struct {
+	creds *Credentials
+	err   error
+	calls int
+}
+
+func (p *countingProvider) Name() string {
+	return "counting"
+}
+
+func (p *countingProvider) Retrieve() (*Credentials, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	creds := *p.creds
+	return &creds, nil
+}
+
+func TestEcsRamRoleProvider(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).UTC().Format(stsTimeFormat)
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr string
+	}{
+		{
+			name:   "success",
+			status: http.StatusOK,
+			body:   `{"Code":"Success","AccessKeyId":"id","AccessKeySecret":"secret","SecurityToken":"token","Expiration":"` + expiration + `"}`,
+		},
+		{
+			name:    "failed code",
+			status:  http.StatusOK,
+			body:    `{"Code":"Failed"}`,
+			wantErr: "responded code Failed",
+		},
+		{
+			name:    "role not found",
+			status:  http.StatusNotFound,
+			body:    "<html>not found</html>",
+			wantErr: "404 Not Found: <html>not found</html>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			p := NewEcsRamRoleProvider("backup").(*ecsRamRoleProvider)
+			p.endpoint = srv.URL
+
+			creds, err := p.Retrieve()
+			if path != "/latest/meta-data/ram/security-credentials/backup" {
+				t.Errorf("requested path %q", path)
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Retrieve() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Retrieve() error = %v", err)
+			}
+			if creds.AccessKeyId != "id" || creds.AccessKeySecret != "secret" || creds.SecurityToken != "token" {
+				t.Errorf("Retrieve() = %+v", creds)
+			}
+			if creds.Expiration.Format(stsTimeFormat) != expiration {
+				t.Errorf("Expiration = %s, want %s", creds.Expiration, expiration)
+			}
+		})
+	}
+}
+
+func TestAssumeRoleProvider(t *testing.T) {
+	source := NewStaticProvider("source-id", "source-secret")
+	expiration := time.Now().Add(time.Hour).UTC().Format(stsTimeFormat)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("RoleArn") == "acs:ram::1:role/missing" {
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte("<html>" + strings.Repeat("x", 2*maxErrorBody) + "</html>"))
+			return
+		}
+
+		// the request is signed by SDK with the credentials of source
+		if q.Get("Signature") == "" || q.Get("SignatureMethod") != "HMAC-SHA1" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"Code":"SignatureDoesNotMatch"}`))
+			return
+		}
+
+		if q.Get("Action") != "AssumeRole" || q.Get("AccessKeyId") != "source-id" || q.Get("RoleSessionName") != defaultRoleSession {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"Code":"InvalidParameter"}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"Credentials":{"AccessKeyId":"sts-id","AccessKeySecret":"sts-secret","SecurityToken":"sts-token","Expiration":"` + expiration + `"}}`))
+	}))
+	defer srv.Close()
+
+	creds, err := NewAssumeRoleProvider(source, &AssumeRoleConfig{Endpoint: srv.URL, RoleArn: "acs:ram::1:role/backup"}).Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyId != "sts-id" || creds.AccessKeySecret != "sts-secret" || creds.SecurityToken != "sts-token" {
+		t.Errorf("Retrieve() = %+v", creds)
+	}
+
+	_, err = NewAssumeRoleProvider(source, &AssumeRoleConfig{Endpoint: srv.URL, RoleArn: "acs:ram::1:role/missing"}).Retrieve()
+	if err == nil || !strings.Contains(err.Error(), "502 Bad Gateway: <html>xxx") {
+		t.Fatalf("Retrieve() error = %v, want the status and body", err)
+	}
+	if len(err.Error()) > 2*maxErrorBody {
+		t.Errorf("error is not bounded, %d bytes", len(err.Error()))
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	for _, name := range append(append(envAccessKeyIds, envAccessKeySecrets...), envSecurityTokens...) {
+		if v, ok := os.LookupEnv(name); ok {
+			defer os.Setenv(name, v)
+		}
+		_ = os.Unsetenv(name)
+	}
+
+	if _, err := NewEnvProvider().Retrieve(); err == nil {
+		t.Fatal("Retrieve() without environment variables should fail")
+	}
+
+	_ = os.Setenv("OSS_ACCESS_KEY_ID", "oss-id")
+	_ = os.Setenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET", "secret")
+	defer os.Unsetenv("OSS_ACCESS_KEY_ID")
+	defer os.Unsetenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+
+	creds, err := NewEnvProvider().Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyId != "oss-id" || creds.AccessKeySecret != "secret" {
+		t.Errorf("Retrieve() = %+v", creds)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "credentials.json")
+	if err := ioutil.WriteFile(filename, []byte(`{"access_key_id":"id","access_key_secret":"secret"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := NewFileProvider(filename).Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve() error = %v", err)
+	}
+	if creds.AccessKeyId != "id" || creds.AccessKeySecret != "secret" {
+		t.Errorf("Retrieve() = %+v", creds)
+	}
+
+	// the file without expiration is reloaded periodically
+	if !creds.expiresWithin(fileReloadInterval+refreshWindow+time.Minute) || creds.expiresWithin(refreshWindow) {
+		t.Errorf("Expiration = %s", creds.Expiration)
+	}
+
+	if _, err := NewFileProvider(filepath.Join(dir, "missing.json")).Retrieve(); err == nil {
+		t.Error("Retrieve() of missing file should fail")
+	}
+}
+
+func TestChainProvider(t *testing.T) {
+	failed := &countingProvider{err: errors.New("unavailable")}
+	working := &countingProvider{creds: &Credentials{AccessKeyId: "id", AccessKeySecret: "secret"}}
+	p := NewChainProvider(failed, working)
+
+	for i := 0; i < 2; i++ {
+		creds, err := p.Retrieve()
+		if err != nil || creds.AccessKeyId != "id" {
+			t.Fatalf("Retrieve() = %+v, %v", creds, err)
+		}
+	}
+
+	// the working provider is kept once found
+	if failed.calls != 1 || working.calls != 2 {
+		t.Errorf("calls = %d, %d, want 1, 2", failed.calls, working.calls)
+	}
+
+	_, err := NewChainProvider(failed).Retrieve()
+	if err == nil || !strings.Contains(err.Error(), "counting: unavailable") {
+		t.Errorf("Retrieve() error = %v", err)
+	}
+}
+
+func TestRefreshingProvider(t *testing.T) {
+	source := &countingProvider{creds: &Credentials{AccessKeyId: "id", AccessKeySecret: "secret", Expiration: time.Now().Add(time.Hour)}}
+	p := NewRefreshingProvider(source)
+
+	for i := 0; i < 3; i++ {
+		if _, err := p.Retrieve(); err != nil {
+			t.Fatalf("Retrieve() error = %v", err)
+		}
+	}
+	if source.calls != 1 {
+		t.Errorf("calls = %d, want the credentials cached", source.calls)
+	}
+
+	// refreshed in the window, the old one is used if the refresh failed
+	source.creds.Expiration = time.Now().Add(time.Minute)
+	p = NewRefreshingProvider(source)
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+	source.err = errors.New("unavailable")
+	creds, err := p.Retrieve()
+	if err != nil || creds.AccessKeyId != "id" {
+		t.Errorf("Retrieve() = %+v, %v, want the cached one", creds, err)
+	}
+	if source.calls != 3 {
+		t.Errorf("calls = %d, want refreshed", source.calls)
+	}
+}
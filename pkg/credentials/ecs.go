@@ -0,0 +1,83 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxErrorBody is the max bytes of body kept in the error of response
+const maxErrorBody = 512
+
+var (
+	// EcsMetadataEndpoint is the address of ECS instance metadata service
+	EcsMetadataEndpoint = "http://100.100.100.200"
+)
+
+type ecsRamRoleProvider struct {
+	endpoint string
+	role     string
+	client   *http.Client
+}
+
+func (p *ecsRamRoleProvider) Name() string {
+	return "ecs_ram_role"
+}
+
+func (p *ecsRamRoleProvider) Retrieve() (*Credentials, error) {
+	url := strings.TrimRight(p.endpoint, "/") + "/latest/meta-data/ram/security-credentials/" + p.role
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata service %w", responseError(resp))
+	}
+
+	var res struct {
+		Code            string `json:"Code"`
+		AccessKeyId     string `json:"AccessKeyId"`
+		AccessKeySecret string `json:"AccessKeySecret"`
+		SecurityToken   string `json:"SecurityToken"`
+		Expiration      string `json:"Expiration"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+
+	if res.Code != "Success" {
+		return nil, fmt.Errorf("metadata service responded code %s", res.Code)
+	}
+
+	creds := &Credentials{AccessKeyId: res.AccessKeyId, AccessKeySecret: res.AccessKeySecret, SecurityToken: res.SecurityToken}
+	if creds.Expiration, err = time.Parse(stsTimeFormat, res.Expiration); err != nil {
+		return nil, err
+	}
+	return creds, creds.validate()
+}
+
+// NewEcsRamRoleProvider creates a provider retrieves the credentials of RAM
+// role attached to the ECS instance from metadata service.
+func NewEcsRamRoleProvider(role string) Provider {
+	return &ecsRamRoleProvider{
+		endpoint: EcsMetadataEndpoint,
+		role:     role,
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// responseError returns the status and the beginning of body of the failed
+// response, which is the error code and message or an error page.
+func responseError(resp *http.Response) error {
+	bs, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if body := strings.TrimSpace(string(bs)); body != "" {
+		return fmt.Errorf("responded %s: %s", resp.Status, body)
+	}
+	return fmt.Errorf("responded %s", resp.Status)
+}
@@ -0,0 +1,47 @@
+package credentials
+
+import (
+	"errors"
+	"os"
+)
+
+var (
+	envAccessKeyIds     = []string{"ALIBABA_CLOUD_ACCESS_KEY_ID", "OSS_ACCESS_KEY_ID"}
+	envAccessKeySecrets = []string{"ALIBABA_CLOUD_ACCESS_KEY_SECRET", "OSS_ACCESS_KEY_SECRET"}
+	envSecurityTokens   = []string{"ALIBABA_CLOUD_SECURITY_TOKEN", "OSS_SESSION_TOKEN"}
+)
+
+type envProvider struct{}
+
+func (p *envProvider) Name() string {
+	return "env"
+}
+
+func (p *envProvider) Retrieve() (*Credentials, error) {
+	creds := &Credentials{
+		AccessKeyId:     lookupEnv(envAccessKeyIds),
+		AccessKeySecret: lookupEnv(envAccessKeySecrets),
+		SecurityToken:   lookupEnv(envSecurityTokens),
+	}
+
+	if creds.AccessKeyId == "" && creds.AccessKeySecret == "" {
+		return nil, errors.New("environment variables not set")
+	}
+	return creds, creds.validate()
+}
+
+// NewEnvProvider creates a provider reads credentials from environment
+// variables ALIBABA_CLOUD_ACCESS_KEY_ID, ALIBABA_CLOUD_ACCESS_KEY_SECRET and
+// ALIBABA_CLOUD_SECURITY_TOKEN (or the OSS_ prefixed ones used by ossutil).
+func NewEnvProvider() Provider {
+	return &envProvider{}
+}
+
+func lookupEnv(names []string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
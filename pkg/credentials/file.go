@@ -0,0 +1,45 @@
+package credentials
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// fileReloadInterval is the interval to reload the credentials file
+	// without expiration, so that rotated keys can be picked up
+	fileReloadInterval = 10 * time.Minute
+)
+
+type fileProvider struct {
+	filename string
+}
+
+func (p *fileProvider) Name() string {
+	return "file"
+}
+
+func (p *fileProvider) Retrieve() (*Credentials, error) {
+	bs, err := ioutil.ReadFile(p.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(bs, &creds); err != nil {
+		return nil, err
+	}
+
+	if creds.Expiration.IsZero() {
+		creds.Expiration = time.Now().Add(fileReloadInterval + refreshWindow)
+	}
+	return &creds, creds.validate()
+}
+
+// NewFileProvider creates a provider reads credentials from a json file with
+// keys access_key_id, access_key_secret, security_token and expiration, which
+// is usually maintained by an external agent.
+func NewFileProvider(filename string) Provider {
+	return &fileProvider{filename: filename}
+}
@@ -0,0 +1,125 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth"
+	sdkcredentials "github.com/aliyun/alibaba-cloud-sdk-go/sdk/auth/credentials"
+	sdkerrors "github.com/aliyun/alibaba-cloud-sdk-go/sdk/errors"
+	"github.com/aliyun/alibaba-cloud-sdk-go/sdk/requests"
+	"github.com/aliyun/alibaba-cloud-sdk-go/services/sts"
+)
+
+const (
+	stsTimeFormat          = "2006-01-02T15:04:05Z"
+	defaultStsEndpoint     = "https://sts.aliyuncs.com"
+	defaultStsRegion       = "cn-hangzhou"
+	defaultRoleSession     = "oss-backup"
+	defaultRoleDurationSec = 3600
+)
+
+type AssumeRoleConfig struct {
+	Endpoint        string
+	RoleArn         string
+	RoleSessionName string
+	DurationSeconds int
+}
+
+type assumeRoleProvider struct {
+	cfg    AssumeRoleConfig
+	source Provider
+}
+
+func (p *assumeRoleProvider) Name() string {
+	return "assume_role(" + p.source.Name() + ")"
+}
+
+func (p *assumeRoleProvider) Retrieve() (*Credentials, error) {
+	source, err := p.source.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+
+	var credential auth.Credential = sdkcredentials.NewAccessKeyCredential(source.AccessKeyId, source.AccessKeySecret)
+	if source.SecurityToken != "" {
+		credential = sdkcredentials.NewStsTokenCredential(source.AccessKeyId, source.AccessKeySecret, source.SecurityToken)
+	}
+
+	client, err := sts.NewClientWithOptions(defaultStsRegion, sdk.NewConfig().WithTimeout(30*time.Second).WithAutoRetry(false), credential)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Shutdown()
+
+	endpoint := p.cfg.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	request := sts.CreateAssumeRoleRequest()
+	request.Scheme, request.Domain = u.Scheme, u.Host
+	request.RoleArn = p.cfg.RoleArn
+	request.RoleSessionName = p.cfg.RoleSessionName
+	request.DurationSeconds = requests.NewInteger(p.cfg.DurationSeconds)
+
+	resp, err := client.AssumeRole(request)
+	if err != nil {
+		return nil, fmt.Errorf("assume role %s: %w", p.cfg.RoleArn, serverError(err))
+	}
+
+	creds := &Credentials{
+		AccessKeyId:     resp.Credentials.AccessKeyId,
+		AccessKeySecret: resp.Credentials.AccessKeySecret,
+		SecurityToken:   resp.Credentials.SecurityToken,
+	}
+	if creds.Expiration, err = time.Parse(stsTimeFormat, resp.Credentials.Expiration); err != nil {
+		return nil, err
+	}
+	return creds, creds.validate()
+}
+
+// NewAssumeRoleProvider creates a provider exchanges the credentials from
+// source for temporary credentials of the role by STS.
+func NewAssumeRoleProvider(source Provider, cfg *AssumeRoleConfig) Provider {
+	p := &assumeRoleProvider{cfg: *cfg, source: source}
+	if p.cfg.Endpoint == "" {
+		p.cfg.Endpoint = defaultStsEndpoint
+	}
+	if p.cfg.RoleSessionName == "" {
+		p.cfg.RoleSessionName = defaultRoleSession
+	}
+	if p.cfg.DurationSeconds == 0 {
+		p.cfg.DurationSeconds = defaultRoleDurationSec
+	}
+
+	return p
+}
+
+// serverError returns the status and the beginning of message of the failed
+// response, the message of SDK is the whole body if it's an error page.
+func serverError(err error) error {
+	var serverErr *sdkerrors.ServerError
+	if !errors.As(err, &serverErr) {
+		return err
+	}
+
+	status := fmt.Sprintf("%d %s", serverErr.HttpStatus(), http.StatusText(serverErr.HttpStatus()))
+	message := serverErr.Message()
+	if len(message) > maxErrorBody {
+		message = message[:maxErrorBody]
+	}
+	if code := serverErr.ErrorCode(); code != "" {
+		message = code + ": " + message
+	}
+	return fmt.Errorf("responded %s: %s", status, strings.TrimSpace(message))
+}
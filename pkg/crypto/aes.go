@@ -5,9 +5,12 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"io"
 )
 
@@ -67,6 +70,15 @@ func (a *Aes) Decrypt(src []byte) (int, []byte) {
 	return int(cnt + 4), dst
 }
 
+// HashKey returns the HMAC-SHA256 of name in hex, the key of HMAC is derived
+// from the password so that it cannot be computed without the password.
+func (a *Aes) HashKey(name string) string {
+	key := sha256.Sum256(append([]byte("oss-backup object key:"), a.key...))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 func (a *Aes) EncryptToBase64(src []byte) string {
 	return base64.URLEncoding.EncodeToString(a.Encrypt(src))
 }
@@ -83,26 +95,30 @@ func (a *Aes) DecryptFromBase64(v string) []byte {
 type proxyReader struct {
 	aes    *Aes
 	source io.Reader
+
+	buf []byte
 }
 
 func (r *proxyReader) Read(p []byte) (int, error) {
-	buf := make([]byte, 16384)
-	rn, err := r.source.Read(buf)
-	if err != nil {
-		return rn, err
-	}
-
-	total := 0
-	bs := r.aes.Encrypt(buf[:rn])
-	for bs != nil {
-		if n, err := bytes.NewReader(bs).Read(p); err != nil || n == len(bs) {
-			return n, err
-		} else if n != len(bs) {
-			total += n
-			bs = bs[n:]
+	if len(r.buf) == 0 {
+		// chunks must be full except the last one, or the padding of them will
+		// be confused with data when decrypting, so that pipes are read fully
+		chunk := make([]byte, 16384)
+		rn, err := io.ReadFull(r.source, chunk)
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil {
+			return 0, err
 		}
+
+		r.buf = r.aes.Encrypt(chunk[:rn])
 	}
-	return total, nil
+
+	// the encrypted chunk is kept if p is smaller than it
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
 }
 
 func (a *Aes) ProxyReader(src io.Reader) io.Reader {
@@ -0,0 +1,94 @@
+package limiter
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// minBandwidthBurst is the minimal burst size of bandwidth limiter
+	minBandwidthBurst = 64 * 1024
+)
+
+// BandwidthLimiter is a token bucket shared by all readers and writers
+// created from it, which limits the total throughput in bytes per second.
+//
+// A nil BandwidthLimiter is valid and does not limit anything.
+type BandwidthLimiter struct {
+	limiter *rate.Limiter
+}
+
+// NewBandwidthLimiter allocates a new BandwidthLimiter, returns nil if the
+// bytesPerSecond is not positive
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+
+	burst := int(bytesPerSecond)
+	if burst < minBandwidthBurst {
+		burst = minBandwidthBurst
+	}
+
+	return &BandwidthLimiter{limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), burst)}
+}
+
+// wait blocks until n bytes are allowed to transfer
+func (b *BandwidthLimiter) wait(ctx context.Context, n int) error {
+	for burst := b.limiter.Burst(); n > 0; n -= burst {
+		chunk := n
+		if chunk > burst {
+			chunk = burst
+		}
+
+		if err := b.limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type bandwidthReader struct {
+	ctx     context.Context
+	limiter *BandwidthLimiter
+	source  io.Reader
+}
+
+func (r *bandwidthReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	if werr := r.limiter.wait(r.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+// Reader returns a reader limited by the bandwidth
+func (b *BandwidthLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if b == nil {
+		return r
+	}
+	return &bandwidthReader{ctx: ctx, limiter: b, source: r}
+}
+
+type bandwidthWriter struct {
+	ctx     context.Context
+	limiter *BandwidthLimiter
+	source  io.Writer
+}
+
+func (w *bandwidthWriter) Write(p []byte) (int, error) {
+	if err := w.limiter.wait(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.source.Write(p)
+}
+
+// Writer returns a writer limited by the bandwidth
+func (b *BandwidthLimiter) Writer(ctx context.Context, w io.Writer) io.Writer {
+	if b == nil {
+		return w
+	}
+	return &bandwidthWriter{ctx: ctx, limiter: b, source: w}
+}
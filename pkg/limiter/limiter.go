@@ -10,6 +10,8 @@ const (
 )
 
 // ConcurrencyLimiter object
+//
+// Deprecated: the jobs cannot report errors or be cancelled, use Pool instead.
 type ConcurrencyLimiter struct {
 	limit         int
 	tickets       chan int
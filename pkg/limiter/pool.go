@@ -0,0 +1,131 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrShutdown is returned by Pool.Go after the pool has been shut down
+var ErrShutdown = errors.New("limiter: pool is shut down")
+
+// Errors aggregates the errors returned by jobs of a Pool
+type Errors []error
+
+func (es Errors) Error() string {
+	msgs := make([]string, 0, len(es))
+	for _, err := range es {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Pool is a worker pool runs at most limit jobs concurrently, the jobs receive
+// a context which is cancelled once the pool is aborted (or any job failed if
+// fail-fast is enabled).
+type Pool struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	failFast bool
+
+	tickets      chan struct{}
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+	wg           sync.WaitGroup
+
+	mu   sync.Mutex
+	errs Errors
+}
+
+// NewPool allocates a new Pool derived from ctx
+func NewPool(ctx context.Context, limit int, failFast bool) *Pool {
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &Pool{
+		ctx:      ctx,
+		cancel:   cancel,
+		failFast: failFast,
+		tickets:  make(chan struct{}, limit),
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Context returns the context passed to the jobs
+func (p *Pool) Context() context.Context {
+	return p.ctx
+}
+
+// Go blocks until a worker is available and then runs the job in it, it
+// returns ErrShutdown or the error of context if the job is not started.
+func (p *Pool) Go(job func(ctx context.Context) error) error {
+	select {
+	case <-p.shutdown:
+		return ErrShutdown
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	case p.tickets <- struct{}{}:
+	}
+
+	// shutdown may happen while waiting for the ticket
+	select {
+	case <-p.shutdown:
+		<-p.tickets
+		return ErrShutdown
+	default:
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.tickets
+			p.wg.Done()
+		}()
+
+		if err := job(p.ctx); err != nil {
+			p.mu.Lock()
+			p.errs = append(p.errs, err)
+			p.mu.Unlock()
+
+			if p.failFast {
+				p.Abort()
+			}
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops accepting new jobs, the running jobs are not affected
+func (p *Pool) Shutdown() {
+	p.shutdownOnce.Do(func() { close(p.shutdown) })
+}
+
+// Abort stops accepting new jobs and cancels the running jobs
+func (p *Pool) Abort() {
+	p.Shutdown()
+	p.cancel()
+}
+
+// Drain blocks until all started jobs are completed, unlike Wait the pool is
+// neither shut down nor cancelled, e.g. for flushing the results of jobs.
+func (p *Pool) Drain() {
+	p.wg.Wait()
+}
+
+// Wait blocks until all started jobs are completed and returns the aggregated
+// errors of them, the pool cannot be used after Wait.
+func (p *Pool) Wait() error {
+	p.wg.Wait()
+	p.Shutdown()
+	p.cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs
+}
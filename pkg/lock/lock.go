@@ -0,0 +1,224 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Kind is the kind of lock objects
+	Kind = "lock"
+	// Dir is the directory of lock objects in bucket
+	Dir = "locks"
+	// DefaultTTL is the time a lock is expired if it's not refreshed, e.g.
+	// the process is killed
+	DefaultTTL = 5 * time.Minute
+
+	metadataExclusive = "Lock-Exclusive"
+	metadataOwner     = "Lock-Owner"
+	metadataHost      = "Lock-Host"
+	metadataPid       = "Lock-Pid"
+	metadataCreated   = "Lock-Created"
+	metadataExpires   = "Lock-Expires"
+)
+
+// Info is the lock object in bucket
+type Info struct {
+	Key       string
+	Exclusive bool
+	Owner     string
+	Host      string
+	Pid       int
+	Created   time.Time
+	Expires   time.Time
+}
+
+func (i *Info) String() string {
+	kind := "shared"
+	if i.Exclusive {
+		kind = "exclusive"
+	}
+	return fmt.Sprintf("%s lock by %s@%s (pid %d) since %s", kind, i.Owner, i.Host, i.Pid, i.Created.Format(time.RFC3339))
+}
+
+// Expired reports whether the lock is not refreshed in time
+func (i *Info) Expired(now time.Time) bool {
+	return now.After(i.Expires)
+}
+
+func (i *Info) metadata() storage.Metadata {
+	md := make(storage.Metadata)
+	md.SetKind(Kind)
+	md.Set(metadataExclusive, strconv.FormatBool(i.Exclusive))
+	md.Set(metadataOwner, i.Owner)
+	md.Set(metadataHost, i.Host)
+	md.Set(metadataPid, strconv.Itoa(i.Pid))
+	md.Set(metadataCreated, strconv.FormatInt(i.Created.Unix(), 10))
+	md.Set(metadataExpires, strconv.FormatInt(i.Expires.Unix(), 10))
+	return md
+}
+
+func parseInfo(key string, md storage.Metadata) *Info {
+	info := &Info{Key: key, Owner: md.Get(metadataOwner), Host: md.Get(metadataHost)}
+	info.Exclusive, _ = strconv.ParseBool(md.Get(metadataExclusive))
+	info.Pid, _ = strconv.Atoi(md.Get(metadataPid))
+	if ts, err := strconv.ParseInt(md.Get(metadataCreated), 10, 64); err == nil {
+		info.Created = time.Unix(ts, 0)
+	}
+	if ts, err := strconv.ParseInt(md.Get(metadataExpires), 10, 64); err == nil {
+		info.Expires = time.Unix(ts, 0)
+	}
+	return info
+}
+
+// ConflictError is returned if the lock is held by others
+type ConflictError struct {
+	Holder *Info
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("repository is locked, %s, remove it by unlock if it's stale", e.Holder)
+}
+
+// List returns the lock objects in bucket, the uploader must have no prefix
+func List(ctx context.Context, uploader storage.Uploader) ([]*Info, error) {
+	var infos []*Info
+	items, wait := uploader.ListObject(ctx, Dir+"/")
+	for item := range items {
+		if item.Metadata.Kind() == Kind {
+			infos = append(infos, parseInfo(item.ObjectKey, item.Metadata))
+		}
+	}
+
+	if err := wait(); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// Lock is a lock held by this process, which is refreshed in background
+// until released.
+type Lock struct {
+	info     *Info
+	uploader storage.Uploader
+	ttl      time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// Shared acquires a lock which can be held with other shared locks, e.g.
+// by backup and download.
+func Shared(ctx context.Context, uploader storage.Uploader) (*Lock, error) {
+	return acquire(ctx, uploader, DefaultTTL)
+}
+
+func acquire(ctx context.Context, uploader storage.Uploader, ttl time.Duration) (*Lock, error) {
+	if err := check(ctx, uploader, ""); err != nil {
+		return nil, err
+	}
+
+	host, _ := os.Hostname()
+	now := time.Now()
+	info := &Info{
+		Key:     fmt.Sprintf("%s/%016x-%d", Dir, now.UnixNano(), os.Getpid()),
+		Owner:   username(),
+		Host:    host,
+		Pid:     os.Getpid(),
+		Created: now,
+		Expires: now.Add(ttl),
+	}
+
+	item := &storage.Item{Filename: info.Key, ObjectKey: info.Key}
+	if err := uploader.Upload(ctx, item, strings.NewReader(""), info.metadata()); err != nil {
+		return nil, fmt.Errorf("create lock: %w", err)
+	}
+
+	// the conflicting lock may be created at the same time
+	if err := check(ctx, uploader, info.Key); err != nil {
+		_ = uploader.Delete(context.Background(), info.Key)
+		return nil, err
+	}
+
+	l := &Lock{info: info, uploader: uploader, ttl: ttl, stop: make(chan struct{}), done: make(chan struct{})}
+	go l.refresh()
+	return l, nil
+}
+
+// check returns ConflictError if any exclusive lock is held, which can not
+// be held with the shared one to acquire
+func check(ctx context.Context, uploader storage.Uploader, self string) error {
+	infos, err := List(ctx, uploader)
+	if err != nil {
+		return fmt.Errorf("list locks: %w", err)
+	}
+
+	now := time.Now()
+	for _, info := range infos {
+		if info.Key == self || info.Expired(now) {
+			continue
+		}
+
+		if info.Exclusive {
+			return &ConflictError{Holder: info}
+		}
+	}
+	return nil
+}
+
+func (l *Lock) refresh() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		l.info.Expires = time.Now().Add(l.ttl)
+		if err := l.uploader.SetMetadata(context.Background(), l.info.Key, l.info.metadata()); err != nil {
+			logger.Warn("refresh lock failed", "key", l.info.Key, "error", err)
+		}
+	}
+}
+
+// Release stops refreshing and deletes the lock, it's safe to be called
+// on nil lock or more than once.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+
+	released := false
+	l.stopOnce.Do(func() {
+		close(l.stop)
+		released = true
+	})
+	if !released {
+		return nil
+	}
+
+	// the lock is deleted even if the run is interrupted
+	<-l.done
+	return l.uploader.Delete(context.Background(), l.info.Key)
+}
+
+func username() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,303 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of level, e.g. info
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return level, nil
+		}
+	}
+
+	if strings.EqualFold(s, "warning") {
+		return LevelWarn, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+// sink is shared by the logger and its children created by With
+type sink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	format string
+}
+
+// Logger writes the records of message with key-value fields, e.g.
+//
+//	logger.Info("upload completed", "file", filename, "bytes", n)
+//
+// the text format is logfmt and the json format is a object per line.
+type Logger struct {
+	sink   *sink
+	fields []interface{}
+}
+
+// New creates a logger writes the records not lower than level to w
+func New(w io.Writer, level Level, format string) (*Logger, error) {
+	if format != FormatText && format != FormatJson {
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+
+	return &Logger{sink: &sink{w: w, level: level, format: format}}, nil
+}
+
+// With returns a child logger with fields added to all records, the field
+// of same key is replaced, e.g. the host of job.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := append(make([]interface{}, 0, len(l.fields)+len(kv)), l.fields...)
+	for ; len(kv) >= 2; kv = kv[2:] {
+		replaced := false
+		for i := 0; i+1 < len(fields); i += 2 {
+			if fields[i] == kv[0] {
+				fields[i+1], replaced = kv[1], true
+				break
+			}
+		}
+
+		if !replaced {
+			fields = append(fields, kv[0], kv[1])
+		}
+	}
+	return &Logger{sink: l.sink, fields: append(fields, kv...)}
+}
+
+// Output returns the writer of logger
+func (l *Logger) Output() io.Writer {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	return l.sink.w
+}
+
+// SetOutput replaces the writer of logger and all its children
+func (l *Logger) SetOutput(w io.Writer) {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	l.sink.w = w
+}
+
+// Sync commits the written records to the storage if the output is a file
+func (l *Logger) Sync() error {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	if fp, ok := l.sink.w.(*os.File); ok && fp != os.Stderr && fp != os.Stdout {
+		return fp.Sync()
+	}
+	return nil
+}
+
+// Enabled reports whether the records of level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.sink.level
+}
+
+func (l *Logger) Log(level Level, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	fields := append(append(make([]interface{}, 0, len(l.fields)+len(kv)), l.fields...), kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(MISSING)")
+	}
+
+	var line []byte
+	if l.sink.format == FormatJson {
+		line = formatJson(time.Now(), level, msg, fields)
+	} else {
+		line = formatText(time.Now(), level, msg, fields)
+	}
+
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	_, _ = l.sink.w.Write(line)
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.Log(LevelDebug, msg, kv...)
+}
+
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.Log(LevelInfo, msg, kv...)
+}
+
+func (l *Logger) Warn(msg string, kv ...interface{}) {
+	l.Log(LevelWarn, msg, kv...)
+}
+
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.Log(LevelError, msg, kv...)
+}
+
+// Fatal is equivalent to Error followed by exit with code 1
+func (l *Logger) Fatal(msg string, kv ...interface{}) {
+	l.Log(LevelError, msg, kv...)
+	_ = l.Sync()
+	os.Exit(1)
+}
+
+func formatText(ts time.Time, level Level, msg string, fields []interface{}) []byte {
+	buf := bytes.Buffer{}
+	buf.WriteString(ts.Format("2006-01-02T15:04:05.000Z07:00"))
+	buf.WriteString(" ")
+	buf.WriteString(strings.ToUpper(level.String()))
+	buf.WriteString(" ")
+	buf.WriteString(msg)
+
+	for i := 0; i < len(fields); i += 2 {
+		buf.WriteString(" ")
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteString("=")
+
+		v := fmt.Sprint(value(fields[i+1]))
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		buf.WriteString(v)
+	}
+
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+func formatJson(ts time.Time, level Level, msg string, fields []interface{}) []byte {
+	record := map[string]interface{}{
+		"time":  ts.Format(time.RFC3339Nano),
+		"level": level.String(),
+		"msg":   msg,
+	}
+
+	keys := make([]string, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		if _, ok := record[key]; !ok {
+			keys = append(keys, key)
+		}
+		record[key] = value(fields[i+1])
+	}
+	sort.Strings(keys)
+
+	// the fixed keys go first for reading
+	buf := bytes.Buffer{}
+	buf.WriteString("{")
+	for i, key := range append([]string{"time", "level", "msg"}, keys...) {
+		if i != 0 {
+			buf.WriteString(",")
+		}
+
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(record[key])
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(record[key]))
+		}
+
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// value converts the field value into the form can be formatted
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	case string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+var std, _ = New(os.Stderr, LevelInfo, FormatText)
+
+// Default returns the logger used by package functions
+func Default() *Logger {
+	return std
+}
+
+// SetDefault replaces the logger used by package functions
+func SetDefault(l *Logger) {
+	std = l
+}
+
+func With(kv ...interface{}) *Logger {
+	return std.With(kv...)
+}
+
+func Debug(msg string, kv ...interface{}) {
+	std.Log(LevelDebug, msg, kv...)
+}
+
+func Info(msg string, kv ...interface{}) {
+	std.Log(LevelInfo, msg, kv...)
+}
+
+func Warn(msg string, kv ...interface{}) {
+	std.Log(LevelWarn, msg, kv...)
+}
+
+func Error(msg string, kv ...interface{}) {
+	std.Log(LevelError, msg, kv...)
+}
+
+// Sync commits the records of default logger, see Logger.Sync
+func Sync() error {
+	return std.Sync()
+}
+
+// Fatal is equivalent to Error followed by exit with code 1
+func Fatal(msg string, kv ...interface{}) {
+	std.Fatal(msg, kv...)
+}
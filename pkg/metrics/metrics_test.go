@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests by op.", "op", "status")
+	files := r.NewGaugeVec("files", "Files of last run.")
+	duration := r.NewHistogramVec("duration_seconds", "Duration of requests.", []float64{1, 0.1}, "op")
+	r.NewCounterVec("unused_total", "Families without series are omitted.")
+
+	requests.Inc("upload", "ok")
+	requests.Add(2, "upload", "ok")
+	requests.Inc("delete", `say "hi"\`+"\n")
+	files.Set(42)
+	duration.Observe(0.05, "upload")
+	duration.Observe(0.5, "upload")
+	duration.Observe(3, "upload")
+
+	buf := bytes.Buffer{}
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo() = %d, wrote %d bytes", n, buf.Len())
+	}
+
+	want := `# HELP duration_seconds Duration of requests.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{op="upload",le="0.1"} 1
+duration_seconds_bucket{op="upload",le="1"} 2
+duration_seconds_bucket{op="upload",le="+Inf"} 3
+duration_seconds_sum{op="upload"} 3.55
+duration_seconds_count{op="upload"} 3
+# HELP files Files of last run.
+# TYPE files gauge
+files 42
+# HELP requests_total Requests by op.
+# TYPE requests_total counter
+requests_total{op="delete",status="say \"hi\"\\\n"} 1
+requests_total{op="upload",status="ok"} 3
+`
+	if buf.String() != want {
+		t.Errorf("WriteTo() wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{0.25, "0.25"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		if got := formatFloat(tt.v); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("requests_total", "", "op")
+
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"duplicate", func() { r.NewGaugeVec("requests_total", "") }},
+		{"label values", func() { c.Inc("upload", "ok") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			tt.fn()
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeVec("up", "").Set(1)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if body := rec.Body.String(); body != "# HELP up \n# TYPE up gauge\nup 1\n" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRegistry()
+	r.NewGaugeVec("up", "").Set(1)
+
+	filename := filepath.Join(dir, "oss_backup.prom")
+	if err := r.WriteTextfile(filename); err != nil {
+		t.Fatalf("WriteTextfile() error = %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil || string(data) != "# HELP up \n# TYPE up gauge\nup 1\n" {
+		t.Errorf("textfile = %q, %v", data, err)
+	}
+
+	// the temporary file is renamed
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(matches) != 0 {
+		t.Errorf("temporary files left: %v", matches)
+	}
+}
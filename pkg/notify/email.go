@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"oss-backup/pkg/conf"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const smtpTimeout = 30 * time.Second
+
+// email sends the message by SMTP, the connection is upgraded by STARTTLS
+// if supported, or TLS from the beginning if smtp_tls is set (e.g. port 465)
+type email struct {
+	addr     string
+	tls      bool
+	username string
+	password string
+	from     string
+	to       []string
+	subject  *template.Template
+}
+
+func newEmail(cfg *conf.Notification) (*email, error) {
+	if cfg.SmtpAddr == "" || cfg.From == "" || len(cfg.To) == 0 {
+		return nil, errors.New("smtp_addr, from and to are required")
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.SmtpAddr); err != nil {
+		return nil, err
+	}
+
+	password, err := cfg.GetPassword()
+	if err != nil {
+		return nil, err
+	}
+
+	text := cfg.Subject
+	if text == "" {
+		text = defaultSubject
+	}
+
+	subject, err := template.New("subject").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return &email{
+		addr:     cfg.SmtpAddr,
+		tls:      cfg.SmtpTls,
+		username: cfg.Username,
+		password: password,
+		from:     cfg.From,
+		to:       cfg.To,
+		subject:  subject,
+	}, nil
+}
+
+func (e *email) Notify(ctx context.Context, event *Event, message string) error {
+	subject := strings.Builder{}
+	if err := e.subject.Execute(&subject, event); err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(e.addr)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(smtpTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	dialer := &net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	if e.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", e.addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", e.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.Close() }()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && !e.tls {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if e.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.username, e.password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(e.from); err != nil {
+		return err
+	}
+	for _, to := range e.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	headers := []string{
+		"From: " + e.from,
+		"To: " + strings.Join(e.to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+	body := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.Replace(message, "\n", "\r\n", -1)
+	if _, err := fmt.Fprint(w, body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
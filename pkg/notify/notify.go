@@ -0,0 +1,122 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/utils"
+	"text/template"
+	"time"
+)
+
+// Event is the summary of a run sent to the sinks
+type Event struct {
+	Job      string        `json:"job"`
+	Host     string        `json:"host"`
+	Action   string        `json:"action"`
+	Status   string        `json:"status"`
+	ExitCode int           `json:"exit_code"`
+	Scanned  int           `json:"files_scanned"`
+	Done     int           `json:"files_done"`
+	Skipped  int           `json:"files_skipped"`
+	Failed   int           `json:"files_failed"`
+	Bytes    int64         `json:"bytes"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"-"`
+	Errors   []string      `json:"errors,omitempty"`
+}
+
+// Success reports whether the run is completed without any failure
+func (e *Event) Success() bool {
+	return e.ExitCode == 0
+}
+
+// HumanBytes returns the bytes in unit, e.g. 1.20GiB
+func (e *Event) HumanBytes() string {
+	return utils.FormatBytes(e.Bytes)
+}
+
+const (
+	defaultSubject  = `[{{.Status}}] oss-backup {{.Job}} on {{.Host}}`
+	defaultTemplate = `[{{.Status}}] oss-backup {{.Job}} on {{.Host}}
+files: {{.Scanned}} scanned, {{.Done}} {{.Action}}, {{.Skipped}} skipped, {{.Failed}} failed
+bytes: {{.HumanBytes}} {{.Action}} in {{.Duration}}
+{{- range .Errors}}
+failed: {{.}}
+{{- end}}
+`
+)
+
+// Notifier sends the message rendered from event
+type Notifier interface {
+	Notify(ctx context.Context, event *Event, message string) error
+}
+
+// Sink is a configured notifier, which is only notified on failures unless
+// it's configured to be always.
+type Sink struct {
+	Name     string
+	always   bool
+	template *template.Template
+	notifier Notifier
+}
+
+// New creates the sink of configure
+func New(cfg *conf.Notification) (*Sink, error) {
+	sink := &Sink{Name: cfg.Name}
+	if sink.Name == "" {
+		sink.Name = cfg.Type
+	}
+
+	switch cfg.On {
+	case "", "failure":
+	case "always":
+		sink.always = true
+	default:
+		return nil, fmt.Errorf("notification %s: unknown on %q, expected failure or always", sink.Name, cfg.On)
+	}
+
+	text := cfg.Template
+	if text == "" {
+		text = defaultTemplate
+	}
+
+	var err error
+	if sink.template, err = template.New(sink.Name).Parse(text); err != nil {
+		return nil, fmt.Errorf("notification %s: %w", sink.Name, err)
+	}
+
+	switch cfg.Type {
+	case "webhook":
+		sink.notifier, err = newWebhook(cfg, webhookPayload)
+	case "slack":
+		sink.notifier, err = newWebhook(cfg, slackPayload)
+	case "dingtalk":
+		sink.notifier, err = newWebhook(cfg, dingTalkPayload)
+	case "email":
+		sink.notifier, err = newEmail(cfg)
+	default:
+		err = fmt.Errorf("unknown type %q", cfg.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("notification %s: %w", sink.Name, err)
+	}
+	return sink, nil
+}
+
+// Notify sends the event if it's required, the message is rendered from
+// the template of sink.
+func (s *Sink) Notify(ctx context.Context, event *Event) error {
+	if event.Success() && !s.always {
+		return nil
+	}
+
+	buf := bytes.Buffer{}
+	if err := s.template.Execute(&buf, event); err != nil {
+		return err
+	}
+
+	return s.notifier.Notify(ctx, event, buf.String())
+}
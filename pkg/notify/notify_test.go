@@ -0,0 +1,398 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"oss-backup/pkg/conf"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newEvent(exitCode int) *Event {
+	status := "success"
+	if exitCode != 0 {
+		status = "failure"
+	}
+
+	return &Event{
+		Job:      "home",
+		Host:     "web-1",
+		Action:   "uploaded",
+		Status:   status,
+		ExitCode: exitCode,
+		Scanned:  3,
+		Done:     2,
+		Failed:   exitCode,
+		Bytes:    2048,
+		Start:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Duration: 1500 * time.Millisecond,
+	}
+}
+
+// recorder is a webhook endpoint recording the requests
+type recorder struct {
+	mu      sync.Mutex
+	status  int
+	headers []http.Header
+	bodies  []map[string]interface{}
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	body := make(map[string]interface{})
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	rec.headers = append(rec.headers, r.Header)
+	rec.bodies = append(rec.bodies, body)
+
+	if rec.status != 0 {
+		w.WriteHeader(rec.status)
+		_, _ = w.Write([]byte("  " + strings.Repeat("x", 1024) + "  "))
+	}
+}
+
+func TestSinkOn(t *testing.T) {
+	tests := []struct {
+		on      string
+		failure int
+		success int
+	}{
+		{"", 1, 0},
+		{"failure", 1, 0},
+		{"always", 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.on, func(t *testing.T) {
+			rec := &recorder{}
+			srv := httptest.NewServer(rec)
+			defer srv.Close()
+
+			sink, err := New(&conf.Notification{Type: "webhook", URL: srv.URL, On: tt.on})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, code := range []int{0, 3} {
+				if err := sink.Notify(context.Background(), newEvent(code)); err != nil {
+					t.Fatalf("Notify() error = %v", err)
+				}
+			}
+
+			failure, success := 0, 0
+			for _, body := range rec.bodies {
+				if body["status"] == "success" {
+					success++
+				} else {
+					failure++
+				}
+			}
+			if failure != tt.failure || success != tt.success {
+				t.Errorf("notified %d failures and %d successes, want %d and %d", failure, success, tt.failure, tt.success)
+			}
+		})
+	}
+
+	if _, err := New(&conf.Notification{Type: "webhook", URL: "http://localhost", On: "never"}); err == nil {
+		t.Error("New() with unknown on should fail")
+	}
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	message := "[failure] oss-backup home on web-1\nfiles: 3 scanned, 2 uploaded, 0 skipped, 1 failed\nbytes: 2.00KiB uploaded in 1.5s\n"
+	tests := []struct {
+		typ   string
+		check func(t *testing.T, body map[string]interface{})
+	}{
+		{"webhook", func(t *testing.T, body map[string]interface{}) {
+			if body["job"] != "home" || body["host"] != "web-1" || body["exit_code"] != float64(1) || body["duration_seconds"] != 1.5 {
+				t.Errorf("payload = %v", body)
+			}
+			if body["message"] != message {
+				t.Errorf("message = %q, want %q", body["message"], message)
+			}
+		}},
+		{"slack", func(t *testing.T, body map[string]interface{}) {
+			if len(body) != 1 || body["text"] != message {
+				t.Errorf("payload = %v", body)
+			}
+		}},
+		{"dingtalk", func(t *testing.T, body map[string]interface{}) {
+			text, _ := body["text"].(map[string]interface{})
+			if body["msgtype"] != "text" || text["content"] != message {
+				t.Errorf("payload = %v", body)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			rec := &recorder{}
+			srv := httptest.NewServer(rec)
+			defer srv.Close()
+
+			sink, err := New(&conf.Notification{Type: tt.typ, URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer token"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sink.Notify(context.Background(), newEvent(1)); err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+
+			if len(rec.bodies) != 1 {
+				t.Fatalf("notified %d times", len(rec.bodies))
+			}
+			if ct := rec.headers[0].Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+			if auth := rec.headers[0].Get("Authorization"); auth != "Bearer token" {
+				t.Errorf("Authorization = %q", auth)
+			}
+			tt.check(t, rec.bodies[0])
+		})
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	rec := &recorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	sink, err := New(&conf.Notification{Type: "slack", URL: srv.URL, Template: "{{.Job}} {{.Status}} {{.HumanBytes}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Notify(context.Background(), newEvent(1)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.bodies[0]["text"] != "home failure 2.00KiB" {
+		t.Errorf("text = %q", rec.bodies[0]["text"])
+	}
+
+	if _, err := New(&conf.Notification{Type: "slack", URL: srv.URL, Template: "{{.Job"}); err == nil {
+		t.Error("New() with invalid template should fail")
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	rec := &recorder{status: http.StatusForbidden}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	sink, err := New(&conf.Notification{Type: "webhook", URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sink.Notify(context.Background(), newEvent(1))
+	if err == nil || !strings.HasPrefix(err.Error(), "webhook responded 403 Forbidden: xxx") {
+		t.Fatalf("Notify() error = %v, want the status and body", err)
+	}
+	if len(err.Error()) > 600 {
+		t.Errorf("error is not bounded, %d bytes", len(err.Error()))
+	}
+
+	if _, err := New(&conf.Notification{Type: "webhook"}); err == nil {
+		t.Error("New() without url should fail")
+	}
+}
+
+// smtpServer is a fake SMTP server accepting a single session, AUTH PLAIN
+// is accepted if the credentials match.
+type smtpServer struct {
+	ln       net.Listener
+	username string
+	password string
+
+	commands []string
+	data     string
+	done     chan struct{}
+}
+
+func newSmtpServer(t *testing.T, username, password string) *smtpServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &smtpServer{ln: ln, username: username, password: password, done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			_, _ = w.WriteString(line + "\r\n")
+		}
+		_ = w.Flush()
+	}
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.commands = append(s.commands, line)
+
+		cmd := strings.ToUpper(strings.Fields(line + " ")[0])
+		switch cmd {
+		case "EHLO":
+			reply("250-localhost", "250-8BITMIME", "250 AUTH PLAIN")
+		case "AUTH":
+			want := base64.StdEncoding.EncodeToString([]byte("\x00" + s.username + "\x00" + s.password))
+			if strings.TrimPrefix(line, "AUTH PLAIN ") == want {
+				reply("235 2.7.0 Authentication successful")
+			} else {
+				reply("535 5.7.8 Authentication credentials invalid")
+			}
+		case "MAIL", "RCPT":
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data := strings.Builder{}
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpServer) wait(t *testing.T) {
+	_ = s.ln.Close()
+	select {
+	case <-s.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("smtp session is not finished")
+	}
+}
+
+func TestEmail(t *testing.T) {
+	srv := newSmtpServer(t, "backup", "secret")
+	sink, err := New(&conf.Notification{
+		Type:     "email",
+		SmtpAddr: srv.ln.Addr().String(),
+		Username: "backup",
+		Password: "secret",
+		From:     "backup@example.com",
+		To:       []string{"ops@example.com", "dev@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Notify(context.Background(), newEvent(1)); err != nil {
+		t.Fatalf("Notify() error = %v", err)
+	}
+	srv.wait(t)
+
+	var verbs []string
+	for _, command := range srv.commands {
+		verbs = append(verbs, strings.Fields(command)[0])
+	}
+
+	// STARTTLS is not advertised by the server
+	if got := strings.Join(verbs, " "); got != "EHLO AUTH MAIL RCPT RCPT DATA QUIT" {
+		t.Errorf("commands = %s", got)
+	}
+	if srv.commands[2] != "MAIL FROM:<backup@example.com> BODY=8BITMIME" && srv.commands[2] != "MAIL FROM:<backup@example.com>" {
+		t.Errorf("MAIL = %q", srv.commands[2])
+	}
+	if srv.commands[3] != "RCPT TO:<ops@example.com>" || srv.commands[4] != "RCPT TO:<dev@example.com>" {
+		t.Errorf("RCPT = %q, %q", srv.commands[3], srv.commands[4])
+	}
+
+	for _, want := range []string{
+		"From: backup@example.com\r\n",
+		"To: ops@example.com, dev@example.com\r\n",
+		"Subject: [failure] oss-backup home on web-1\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\n[failure] oss-backup home on web-1\r\nfiles: 3 scanned, 2 uploaded, 0 skipped, 1 failed\r\n",
+	} {
+		if !strings.Contains(srv.data, want) {
+			t.Errorf("data does not contain %q:\n%s", want, srv.data)
+		}
+	}
+}
+
+func TestEmailAuthFailed(t *testing.T) {
+	srv := newSmtpServer(t, "backup", "secret")
+	sink, err := New(&conf.Notification{
+		Type:     "email",
+		SmtpAddr: srv.ln.Addr().String(),
+		Username: "backup",
+		Password: "wrong",
+		From:     "backup@example.com",
+		To:       []string{"ops@example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sink.Notify(context.Background(), newEvent(1))
+	if err == nil || !strings.Contains(err.Error(), "535") {
+		t.Errorf("Notify() error = %v, want authentication failed", err)
+	}
+	srv.wait(t)
+
+	for _, command := range srv.commands {
+		if strings.HasPrefix(command, "MAIL") {
+			t.Error("mail is sent after authentication failed")
+		}
+	}
+}
+
+func TestEmailConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  conf.Notification
+	}{
+		{"missing to", conf.Notification{SmtpAddr: "localhost:25", From: "a@example.com"}},
+		{"missing port", conf.Notification{SmtpAddr: "localhost", From: "a@example.com", To: []string{"b@example.com"}}},
+		{"missing password file", conf.Notification{SmtpAddr: "localhost:25", From: "a@example.com", To: []string{"b@example.com"}, PasswordFile: "/nonexistent/smtp-password"}},
+		{"invalid subject", conf.Notification{SmtpAddr: "localhost:25", From: "a@example.com", To: []string{"b@example.com"}, Subject: "{{"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.Type = "email"
+			if _, err := New(&tt.cfg); err == nil {
+				t.Error("New() should fail")
+			}
+		})
+	}
+}
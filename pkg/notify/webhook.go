@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"oss-backup/pkg/conf"
+	"time"
+)
+
+const webhookTimeout = 30 * time.Second
+
+// webhook posts the payload in json
+type webhook struct {
+	url     string
+	headers map[string]string
+	payload func(event *Event, message string) interface{}
+	client  *http.Client
+}
+
+func newWebhook(cfg *conf.Notification, payload func(*Event, string) interface{}) (*webhook, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("url is required")
+	}
+
+	return &webhook{
+		url:     cfg.URL,
+		headers: cfg.Headers,
+		payload: payload,
+		client:  &http.Client{Timeout: webhookTimeout},
+	}, nil
+}
+
+func (w *webhook) Notify(ctx context.Context, event *Event, message string) error {
+	body, err := json.Marshal(w.payload(event, message))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook responded %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// webhookPayload is the event with the rendered message
+func webhookPayload(event *Event, message string) interface{} {
+	return struct {
+		*Event
+		DurationSeconds float64 `json:"duration_seconds"`
+		Message         string  `json:"message"`
+	}{event, event.Duration.Seconds(), message}
+}
+
+// slackPayload is the incoming webhook message of Slack, which is also
+// accepted by Mattermost, Rocket.Chat and so on
+func slackPayload(_ *Event, message string) interface{} {
+	return map[string]string{"text": message}
+}
+
+// dingTalkPayload is the text message of DingTalk robot
+func dingTalkPayload(_ *Event, message string) interface{} {
+	return map[string]interface{}{
+		"msgtype": "text",
+		"text":    map[string]string{"content": message},
+	}
+}
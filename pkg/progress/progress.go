@@ -0,0 +1,272 @@
+package progress
+
+import (
+	"context"
+	"io"
+	"os"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/utils"
+	"sync"
+	"time"
+)
+
+const (
+	// RefreshInterval is the interval of redrawing the terminal display
+	RefreshInterval = 200 * time.Millisecond
+	// ReportInterval is the interval of progress lines when not on terminal
+	ReportInterval = 10 * time.Second
+)
+
+// Tracker aggregates the files and bytes of all concurrent transfers, the
+// progress is drawn live on terminal or reported periodically by lines.
+//
+// A nil Tracker is valid and tracks nothing.
+type Tracker struct {
+	mu     sync.Mutex
+	w      io.Writer
+	tty    bool
+	start  time.Time
+	action string
+
+	// files and bytes scanned, processed (transferred, skipped or failed)
+	files          int
+	bytes          int64
+	processedFiles int
+	processedBytes int64
+	failedFiles    int
+
+	// bytes sent or received including the active transfers
+	transferred int64
+	active      []*Transfer
+	lines       int
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// New starts a tracker writing to w, the display is live if w is a terminal
+func New(w io.Writer, action string) *Tracker {
+	t := &Tracker{
+		w:       w,
+		start:   time.Now(),
+		action:  action,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	interval := ReportInterval
+	if fp, ok := w.(*os.File); ok && utils.IsTerminal(fp) {
+		t.tty, interval = true, RefreshInterval
+	}
+
+	go t.run(interval)
+	return t
+}
+
+func (t *Tracker) run(interval time.Duration) {
+	defer close(t.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			t.mu.Lock()
+			if t.tty {
+				t.erase()
+				t.draw()
+				t.mu.Unlock()
+				continue
+			}
+
+			// logging outside the lock, the logger may write to tracker
+			kv := t.report()
+			t.mu.Unlock()
+			logger.Info("progress", kv...)
+		}
+	}
+}
+
+// Stop stops the tracker and clears the live display
+func (t *Tracker) Stop() {
+	if t == nil {
+		return
+	}
+
+	close(t.done)
+	<-t.stopped
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// the logs written after stopped are passed through
+	t.erase()
+	t.tty = false
+}
+
+// Live reports whether the progress is drawn live on terminal
+func (t *Tracker) Live() bool {
+	return t.tty
+}
+
+// Write writes the p above the live display, so that the logs can be
+// printed while tracking.
+func (t *Tracker) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.erase()
+	n, err := t.w.Write(p)
+	if t.tty {
+		t.draw()
+	}
+	return n, err
+}
+
+// Expect adds a file of size to process, a negative size is unknown
+func (t *Tracker) Expect(size int64) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.files++
+	if size > 0 {
+		t.bytes += size
+	}
+}
+
+// Finish marks a file of size processed, whether it's transferred or not
+func (t *Tracker) Finish(size int64, failed bool) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.processedFiles++
+	if size > 0 {
+		t.processedBytes += size
+	}
+	if failed {
+		t.failedFiles++
+	}
+}
+
+// Start adds an active transfer of the file, a negative size is unknown
+func (t *Tracker) Start(name string, size int64) *Transfer {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tr := &Transfer{tracker: t, name: name, size: size}
+	t.active = append(t.active, tr)
+	return tr
+}
+
+// Transfer is the progress of a single file, which is nil-safe as Tracker
+type Transfer struct {
+	tracker  *Tracker
+	name     string
+	size     int64
+	consumed int64
+}
+
+// Update sets the bytes transferred, it may go back if the transfer retried
+func (tr *Transfer) Update(consumed int64) {
+	if tr == nil {
+		return
+	}
+
+	tr.tracker.mu.Lock()
+	defer tr.tracker.mu.Unlock()
+
+	tr.tracker.transferred += consumed - tr.consumed
+	tr.consumed = consumed
+}
+
+// Done removes the transfer from the active ones
+func (tr *Transfer) Done() {
+	if tr == nil {
+		return
+	}
+
+	t := tr.tracker
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, active := range t.active {
+		if active == tr {
+			t.active = append(t.active[:i], t.active[i+1:]...)
+			break
+		}
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the tracker
+func NewContext(ctx context.Context, t *Tracker) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tracker in context, or nil if absent
+func FromContext(ctx context.Context) *Tracker {
+	t, _ := ctx.Value(contextKey{}).(*Tracker)
+	return t
+}
+
+// report returns the fields of overall progress for logging
+func (t *Tracker) report() []interface{} {
+	elapsed := time.Since(t.start)
+	return []interface{}{
+		"action", t.action,
+		"files", t.processedFiles,
+		"files_total", t.files,
+		"failed", t.failedFiles,
+		"bytes", t.processedBytes + t.inflight(),
+		"bytes_total", t.bytes,
+		"rate", utils.FormatBytes(t.rate(elapsed)) + "/s",
+		"eta", formatEta(t.eta(elapsed)),
+	}
+}
+
+// inflight returns the bytes of active transfers
+func (t *Tracker) inflight() int64 {
+	var n int64
+	for _, tr := range t.active {
+		n += tr.consumed
+	}
+	return n
+}
+
+func (t *Tracker) rate(elapsed time.Duration) int64 {
+	if elapsed < time.Second {
+		return 0
+	}
+	return int64(float64(t.transferred) / elapsed.Seconds())
+}
+
+// eta returns the estimated time to process the remaining bytes, or a
+// negative duration if it's unknown yet
+func (t *Tracker) eta(elapsed time.Duration) time.Duration {
+	rate := t.rate(elapsed)
+	if rate <= 0 {
+		return -1
+	}
+
+	remaining := t.bytes - t.processedBytes - t.inflight()
+	if remaining < 0 {
+		remaining = 0
+	}
+	return time.Duration(float64(remaining) / float64(rate) * float64(time.Second))
+}
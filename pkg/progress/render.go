@@ -0,0 +1,79 @@
+package progress
+
+import (
+	"fmt"
+	"oss-backup/pkg/utils"
+	"strings"
+	"time"
+)
+
+const (
+	// maxActiveLines is the max number of transfers drawn on terminal
+	maxActiveLines = 8
+	nameWidth      = 40
+	barWidth       = 24
+)
+
+// erase clears the lines drawn previously
+func (t *Tracker) erase() {
+	if t.lines != 0 {
+		_, _ = fmt.Fprint(t.w, strings.Repeat("\x1b[1A\x1b[2K", t.lines))
+		t.lines = 0
+	}
+}
+
+// draw prints the active transfers and the overall line
+func (t *Tracker) draw() {
+	var lines []string
+	for i, tr := range t.active {
+		if i == maxActiveLines {
+			lines = append(lines, fmt.Sprintf("  ... and %d more", len(t.active)-maxActiveLines))
+			break
+		}
+		lines = append(lines, tr.line())
+	}
+
+	elapsed := time.Since(t.start)
+	lines = append(lines, fmt.Sprintf("%d/%d files, %d failed, %s/%s %s, %s/s, ETA %s",
+		t.processedFiles, t.files, t.failedFiles,
+		utils.FormatBytes(t.processedBytes+t.inflight()), utils.FormatBytes(t.bytes), t.action,
+		utils.FormatBytes(t.rate(elapsed)), formatEta(t.eta(elapsed))))
+
+	_, _ = fmt.Fprint(t.w, strings.Join(lines, "\n")+"\n")
+	t.lines = len(lines)
+}
+
+func (tr *Transfer) line() string {
+	name := []rune(tr.name)
+	if len(name) > nameWidth {
+		name = append([]rune("..."), name[len(name)-nameWidth+3:]...)
+	}
+
+	if tr.size < 0 {
+		return fmt.Sprintf("  %-*s %s", nameWidth, string(name), utils.FormatBytes(tr.consumed))
+	}
+
+	fraction := 1.0
+	if tr.size > 0 {
+		fraction = float64(tr.consumed) / float64(tr.size)
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+
+	filled := int(fraction * barWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	if filled > 0 && filled < barWidth {
+		bar = bar[:filled-1] + ">" + bar[filled:]
+	}
+
+	return fmt.Sprintf("  %-*s [%s] %5.1f%% %s/%s", nameWidth, string(name), bar,
+		fraction*100, utils.FormatBytes(tr.consumed), utils.FormatBytes(tr.size))
+}
+
+func formatEta(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	return d.Round(time.Second).String()
+}
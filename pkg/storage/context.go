@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"oss-backup/pkg/progress"
+)
+
+// contextReader makes the reader fail once the context is done, so that the
+// transfer in SDK can be aborted.
+type contextReader struct {
+	ctx    context.Context
+	source io.Reader
+	n      int64
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.source.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type contextWriter struct {
+	ctx    context.Context
+	source io.Writer
+	n      int64
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := w.source.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// trackable is the data of transfer which counts the progress by itself,
+// rather than by the bytes sent or received.
+type trackable interface {
+	track(transfer *progress.Transfer)
+}
+
+// trackReader reports the progress of reading the data to the transfer
+func trackReader(data io.Reader, transfer *progress.Transfer) io.Reader {
+	if t, ok := data.(trackable); ok {
+		t.track(transfer)
+		return data
+	}
+	return &progressReader{Reader: data, transfer: transfer}
+}
+
+// trackWriter reports the progress of writing the data to the transfer
+func trackWriter(w io.Writer, transfer *progress.Transfer) io.Writer {
+	if t, ok := w.(trackable); ok {
+		t.track(transfer)
+		return w
+	}
+	return &progressWriter{Writer: w, transfer: transfer}
+}
+
+type progressReader struct {
+	io.Reader
+	transfer *progress.Transfer
+	n        int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	r.transfer.Update(r.n)
+	return n, err
+}
+
+type progressWriter struct {
+	io.Writer
+	transfer *progress.Transfer
+	n        int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	w.transfer.Update(w.n)
+	return n, err
+}
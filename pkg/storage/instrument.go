@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"oss-backup/pkg/metrics"
+	"time"
+)
+
+// instrumentedUploader records the count and latency of requests per method
+type instrumentedUploader struct {
+	uploader Uploader
+	requests *metrics.CounterVec
+	latency  *metrics.HistogramVec
+}
+
+// Instrument wraps the uploader, the requests are counted by labels method
+// and result (ok or error), the latency is observed by label method.
+func Instrument(uploader Uploader, requests *metrics.CounterVec, latency *metrics.HistogramVec) Uploader {
+	return &instrumentedUploader{uploader: uploader, requests: requests, latency: latency}
+}
+
+func (u *instrumentedUploader) observe(method string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	u.requests.Inc(method, result)
+	u.latency.Observe(time.Since(start).Seconds(), method)
+}
+
+func (u *instrumentedUploader) Exists(ctx context.Context, key string) (bool, error) {
+	start := time.Now()
+	ok, err := u.uploader.Exists(ctx, key)
+	u.observe("exists", start, err)
+	return ok, err
+}
+
+func (u *instrumentedUploader) Metadata(ctx context.Context, key string) (Metadata, error) {
+	start := time.Now()
+	md, err := u.uploader.Metadata(ctx, key)
+	u.observe("metadata", start, err)
+	return md, err
+}
+
+// ListObject observes the latency until all the items are received
+func (u *instrumentedUploader) ListObject(ctx context.Context, prefix string) (chan *Item, func() error) {
+	start := time.Now()
+	items, wait := u.uploader.ListObject(ctx, prefix)
+
+	ch := make(chan *Item, cap(items))
+	go func() {
+		for item := range items {
+			ch <- item
+		}
+
+		u.observe("list_object", start, wait())
+		close(ch)
+	}()
+
+	return ch, wait
+}
+
+func (u *instrumentedUploader) SetMetadata(ctx context.Context, key string, metadata Metadata) error {
+	start := time.Now()
+	err := u.uploader.SetMetadata(ctx, key, metadata)
+	u.observe("set_metadata", start, err)
+	return err
+}
+
+func (u *instrumentedUploader) Upload(ctx context.Context, item *Item, reader io.Reader, metadata Metadata) error {
+	start := time.Now()
+	err := u.uploader.Upload(ctx, item, reader, metadata)
+	u.observe("upload", start, err)
+	return err
+}
+
+func (u *instrumentedUploader) Delete(ctx context.Context, key string) error {
+	start := time.Now()
+	err := u.uploader.Delete(ctx, key)
+	u.observe("delete", start, err)
+	return err
+}
+
+func (u *instrumentedUploader) Download(ctx context.Context, item *Item, w io.Writer) error {
+	start := time.Now()
+	err := u.uploader.Download(ctx, item, w)
+	u.observe("download", start, err)
+	return err
+}
@@ -3,11 +3,11 @@ package storage
 import (
 	"context"
 	"io"
-	"log"
-	"math/rand"
 	"oss-backup/pkg/conf"
+	"oss-backup/pkg/credentials"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/progress"
 	"strings"
-	"sync"
 
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 )
@@ -31,125 +31,177 @@ func (cfg *AliYunConfig) Validate() bool {
 type AliYunOSS struct {
 	cfg    *conf.Bucket
 	bucket *oss.Bucket
+	retry  *RetryPolicy
 }
 
-type progress struct {
-	item *Item
-}
-
-func (p *progress) ProgressChanged(event *oss.ProgressEvent) {
-	switch event.EventType {
-	case oss.TransferStartedEvent:
-		log.Printf("%s started", p.item.Filename)
-	case oss.TransferDataEvent:
-		percent := float64(event.ConsumedBytes) / float64(p.item.FileSize) * 100
-		if percent > 100 {
-			percent = 100
-		}
-
-		if percent != 100 {
-			if rand.Intn(100) != 1 {
-				break
-			}
-		}
+func (ao *AliYunOSS) Exists(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := ao.retry.Do(ctx, "exists", key, func() (err error) {
+		ok, err = ao.bucket.IsObjectExist(ao.genObjectKey(key))
+		return err
+	})
 
-		log.Printf("%s ... %.2f%%", p.item.Filename, percent)
-	case oss.TransferCompletedEvent:
-		log.Printf("%s completed", p.item.Filename)
-	case oss.TransferFailedEvent:
-		log.Printf("%s failed", p.item.Filename)
-	}
+	return ok, err
 }
 
-func (ao *AliYunOSS) Exists(key string) bool {
-	ok, err := ao.bucket.IsObjectExist(ao.genObjectKey(key))
-	return ok && err == nil
+func (ao *AliYunOSS) Metadata(ctx context.Context, key string) (Metadata, error) {
+	return ao.metadata(ctx, ao.genObjectKey(key))
 }
 
-func (ao *AliYunOSS) Metadata(key string) Metadata {
+// metadata gets the metadata by the full key of object
+func (ao *AliYunOSS) metadata(ctx context.Context, key string) (Metadata, error) {
 	md := make(Metadata)
-	if props, err := ao.bucket.GetObjectDetailedMeta(ao.genObjectKey(key)); err == nil {
+	err := ao.retry.Do(ctx, "metadata", key, func() error {
+		props, err := ao.bucket.GetObjectDetailedMeta(key)
+		if err != nil {
+			return err
+		}
+
 		for k, vs := range props {
 			if len(vs) != 0 {
 				md[k] = vs[0]
 			}
 		}
-	}
+		return nil
+	})
 
-	return md
+	return md, err
 }
 
-func (ao *AliYunOSS) ListObject(prefix string) chan *Item {
-	marker := ""
-	ch := make(chan *Item, 1024)
-	wg := sync.WaitGroup{}
-
-	go func() {
-		for {
-			opts := []oss.Option{oss.Marker(marker), oss.MaxKeys(1000)}
-			if prefix != "" {
-				opts = append(opts, oss.Prefix(prefix))
-			}
+func (ao *AliYunOSS) SetMetadata(ctx context.Context, key string, metadata Metadata) error {
+	var opts []oss.Option
+	for k, v := range metadata {
+		opts = append(opts, oss.Meta(k, v))
+	}
 
-			res, err := ao.bucket.ListObjects(opts...)
-			if err != nil {
-				log.Fatal(err)
-			}
+	return ao.retry.Do(ctx, "set_metadata", key, func() error {
+		return ao.bucket.SetObjectMeta(ao.genObjectKey(key), opts...)
+	})
+}
 
-			for _, obj := range res.Objects {
-				wg.Add(1)
-				item := &Item{ObjectKey: obj.Key, FileSize: obj.Size}
-				go func(item *Item) {
-					item.Metadata = ao.Metadata(item.ObjectKey)
-					item.FileSize = int64(item.Metadata.FileSize())
-					ch <- item
-					wg.Done()
-				}(item)
-			}
+// ListObject lists the objects under the prefix, the error of listing is
+// returned by wait after the channel closed.
+func (ao *AliYunOSS) ListObject(ctx context.Context, prefix string) (chan *Item, func() error) {
+	return listItems(prefix, func(marker string) ([]string, string, error) {
+		opts := []oss.Option{oss.Marker(marker), oss.MaxKeys(1000)}
+		if prefix != "" {
+			opts = append(opts, oss.Prefix(prefix))
+		}
 
-			if res.IsTruncated {
-				marker = res.NextMarker
-			} else {
-				break
-			}
+		var res oss.ListObjectsResult
+		if err := ao.retry.Do(ctx, "list", prefix, func() (err error) {
+			res, err = ao.bucket.ListObjects(opts...)
+			return err
+		}); err != nil {
+			return nil, "", err
 		}
 
-		wg.Wait()
-		close(ch)
-	}()
+		keys := make([]string, 0, len(res.Objects))
+		for _, obj := range res.Objects {
+			keys = append(keys, obj.Key)
+		}
 
-	return ch
+		next := ""
+		if res.IsTruncated {
+			next = res.NextMarker
+		}
+		return keys, next, nil
+	}, func(key string) (Metadata, error) {
+		return ao.metadata(ctx, key)
+	})
 }
 
+// Upload puts the object, the data is read again on retry if it's a Rewinder
 func (ao *AliYunOSS) Upload(ctx context.Context, item *Item, data io.Reader, metadata Metadata) error {
-	opts := []oss.Option{oss.Progress(&progress{item: item})}
+	transfer := progress.FromContext(ctx).Start(item.Filename, item.FileSize)
+	defer transfer.Done()
+
+	var opts []oss.Option
 	for k, v := range metadata {
 		opts = append(opts, oss.Meta(k, v))
 	}
 
-	return ao.bucket.PutObject(ao.genObjectKey(item.ObjectKey), data, opts...)
+	// the progress is counted before encrypted, as the size of item
+	tracked := trackReader(data, transfer)
+	return ao.retry.Do(ctx, "upload", item.ObjectKey, func() error {
+		rd := &contextReader{ctx: ctx, source: tracked}
+		err := ao.bucket.PutObject(ao.genObjectKey(item.ObjectKey), rd, opts...)
+		return rewind(err, data, rd.n)
+	})
 }
 
+// Download gets the object, or the range of pack if the item is packed. The
+// writer is restarted on retry if it's a Rewinder.
 func (ao *AliYunOSS) Download(ctx context.Context, item *Item, w io.Writer) error {
-	rd, err := ao.bucket.GetObject(item.ObjectKey, oss.AcceptEncoding("gzip"), oss.Progress(&progress{item: item}))
-	if err != nil {
-		return err
+	transfer := progress.FromContext(ctx).Start(item.Filename, item.FileSize)
+	defer transfer.Done()
+
+	key := item.ObjectKey
+	opts := []oss.Option{oss.AcceptEncoding("gzip")}
+	if item.Pack != nil {
+		key = item.Pack.Object
+		opts = append(opts, oss.Range(item.Pack.Offset, item.Pack.Offset+item.Pack.Length-1))
 	}
 
-	_, err = io.Copy(w, rd)
-	return err
+	// the progress is counted after decrypted, as the size of item
+	tracked := trackWriter(w, transfer)
+	return ao.retry.Do(ctx, "download", key, func() error {
+		rd, err := ao.bucket.GetObject(key, opts...)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rd.Close() }()
+
+		cw := &contextWriter{ctx: ctx, source: tracked}
+		_, err = io.Copy(cw, rd)
+		return rewind(err, w, cw.n)
+	})
+}
+
+func (ao *AliYunOSS) Delete(ctx context.Context, key string) error {
+	return ao.retry.Do(ctx, "delete", key, func() error {
+		return ao.bucket.DeleteObject(ao.genObjectKey(key))
+	})
 }
 
 var (
 	trim = func(s string) string { return strings.Trim(s, "/\\") }
 )
 
+// ObjectKey joins the prefix and key into the full key of object
+func ObjectKey(prefix, key string) string {
+	return trim(trim(prefix) + "/" + trim(key))
+}
+
 func (ao *AliYunOSS) genObjectKey(key string) string {
-	return trim(trim(ao.cfg.ObjectPrefix) + "/" + trim(key))
+	return ObjectKey(ao.cfg.ObjectPrefix, key)
+}
+
+type credentialsProvider struct {
+	provider credentials.Provider
+}
+
+func (p *credentialsProvider) GetCredentials() oss.Credentials {
+	creds, err := p.provider.Retrieve()
+	if err != nil {
+		logger.Error("retrieve credentials failed", "provider", p.provider.Name(), "error", err)
+		return &credentials.Credentials{}
+	}
+	return creds
 }
 
 func NewAliYunOSS(cfg *conf.Bucket) (*AliYunOSS, error) {
-	client, err := oss.New(cfg.Endpoint, cfg.AccessKeyId, cfg.AccessKeySecret)
+	retry, err := NewRetryPolicy(cfg.Retry)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := credentials.ForBucket(cfg)
+	if _, err := provider.Retrieve(); err != nil {
+		return nil, err
+	}
+
+	client, err := oss.New(cfg.Endpoint, "", "", oss.SetCredentialsProvider(&credentialsProvider{provider: provider}))
 	if err != nil {
 		return nil, err
 	}
@@ -159,5 +211,5 @@ func NewAliYunOSS(cfg *conf.Bucket) (*AliYunOSS, error) {
 		return nil, err
 	}
 
-	return &AliYunOSS{cfg: cfg, bucket: bucket}, nil
+	return &AliYunOSS{cfg: cfg, bucket: bucket, retry: retry}, nil
 }
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+const (
+	// KindPack is the kind of objects bundling the data of small files
+	KindPack = "pack"
+	// PackDir is the directory of pack objects under the prefix
+	PackDir = "packs"
+)
+
+// PackRef locates the data of a file in the pack object
+type PackRef struct {
+	Object string `json:"object"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// PackEntry is the record of a file in the index of pack
+type PackEntry struct {
+	Key      string `json:"key"`
+	Filename string `json:"filename"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+	FileSize int64  `json:"file_size"`
+	ModTime  int64  `json:"mod_time"`
+	// Metadata is the metadata of file as it would be uploaded as an object
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// Pack is the data of files followed by the index of them, both are
+// encrypted before added, so that a file can be read by range.
+type Pack struct {
+	buf     bytes.Buffer
+	entries []*PackEntry
+	created time.Time
+}
+
+func NewPack() *Pack {
+	return &Pack{created: time.Now()}
+}
+
+// Add appends the encrypted data of file, the range of entry is updated
+func (p *Pack) Add(entry *PackEntry, data []byte) {
+	entry.Offset, entry.Length = int64(p.buf.Len()), int64(len(data))
+	p.buf.Write(data)
+	p.entries = append(p.entries, entry)
+}
+
+// Size returns the bytes of data added
+func (p *Pack) Size() int64 {
+	return int64(p.buf.Len())
+}
+
+// Len returns the number of files added
+func (p *Pack) Len() int {
+	return len(p.entries)
+}
+
+// Key returns the object key of pack, which is sorted by the creation
+func (p *Pack) Key() string {
+	return fmt.Sprintf("%s/%016x", PackDir, p.created.UnixNano())
+}
+
+// Seal appends the index encrypted by wrap, returns the content and metadata
+// of pack object
+func (p *Pack) Seal(wrap func(io.Reader) io.Reader) (*bytes.Reader, Metadata, error) {
+	index, err := json.Marshal(p.entries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encrypted, err := ioutil.ReadAll(wrap(bytes.NewReader(index)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	md := make(Metadata)
+	md.SetKind(KindPack)
+	md.SetModTime(p.created.Unix())
+	md.SetPackIndex(int64(p.buf.Len()), int64(len(encrypted)))
+
+	data := append(p.buf.Bytes(), encrypted...)
+	md.SetFileSize(len(data))
+	return bytes.NewReader(data), md, nil
+}
+
+// ReadPackIndex reads the index of pack decrypted by wrap, the files in pack
+// are returned as items referring to the pack.
+func ReadPackIndex(ctx context.Context, uploader Uploader, pack *Item, wrap func(io.Writer) io.Writer) ([]*Item, error) {
+	offset, length := pack.Metadata.PackIndex()
+	if length <= 0 {
+		return nil, fmt.Errorf("no index in pack %s", pack.ObjectKey)
+	}
+
+	buf := bytes.Buffer{}
+	ref := &Item{ObjectKey: pack.ObjectKey, FileSize: length, Pack: &PackRef{Object: pack.ObjectKey, Offset: offset, Length: length}}
+	if err := uploader.Download(ctx, ref, wrap(&buf)); err != nil {
+		return nil, err
+	}
+
+	var entries []*PackEntry
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		return nil, fmt.Errorf("decode index of pack %s: %w", pack.ObjectKey, err)
+	}
+
+	items := make([]*Item, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entry.Item(pack.ObjectKey))
+	}
+	return items, nil
+}
+
+// Item returns the item of file in pack, the metadata is keyed as it's
+// returned by storage
+func (e *PackEntry) Item(pack string) *Item {
+	md := Metadata{
+		propPrefix + metadataModifyTimestamp: strconv.FormatInt(e.ModTime, 10),
+		propPrefix + metadataFileSize:        strconv.FormatInt(e.FileSize, 10),
+	}
+	for k, v := range e.Metadata {
+		md[propPrefix+k] = v
+	}
+
+	return &Item{
+		Filename:  e.Filename,
+		ObjectKey: e.Key,
+		FileSize:  e.FileSize,
+		ModTime:   time.Unix(e.ModTime, 0),
+		Metadata:  md,
+		Pack:      &PackRef{Object: pack, Offset: e.Offset, Length: e.Length},
+	}
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"oss-backup/pkg/crypto"
+	"testing"
+	"time"
+)
+
+// memUploader keeps the objects in memory, only Download is implemented
+type memUploader struct {
+	Uploader
+	objects   map[string][]byte
+	downloads []*Item
+}
+
+func (u *memUploader) Download(_ context.Context, item *Item, w io.Writer) error {
+	u.downloads = append(u.downloads, item)
+
+	data, ok := u.objects[item.ObjectKey]
+	if item.Pack != nil {
+		data, ok = u.objects[item.Pack.Object]
+		if ok {
+			data = data[item.Pack.Offset : item.Pack.Offset+item.Pack.Length]
+		}
+	}
+	if !ok {
+		return errors.New("no such key")
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+// stored returns the metadata as it's returned by storage
+func stored(md Metadata) Metadata {
+	prefixed := make(Metadata)
+	for k, v := range md {
+		prefixed[propPrefix+k] = v
+	}
+	return prefixed
+}
+
+func encrypt(t *testing.T, aes *crypto.Aes, data string) []byte {
+	encrypted, err := ioutil.ReadAll(aes.ProxyReader(bytes.NewReader([]byte(data))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encrypted
+}
+
+func TestPackSealAndReadIndex(t *testing.T) {
+	aes, err := crypto.NewAes(&crypto.AesConfig{Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []struct {
+		key, filename, content string
+	}{
+		{"backup/a", "/home/a.txt", "hello"},
+		{"backup/b", "/home/b.txt", ""},
+		{"backup/c", "/home/c.txt", "the content longer than a block of aes"},
+	}
+
+	pack := NewPack()
+	for i, f := range files {
+		md := make(Metadata)
+		md.SetChecksum("sum-" + f.key)
+		pack.Add(&PackEntry{
+			Key:      f.key,
+			Filename: f.filename,
+			FileSize: int64(len(f.content)),
+			ModTime:  int64(1600000000 + i),
+			Metadata: md,
+		}, encrypt(t, aes, f.content))
+	}
+	if pack.Len() != len(files) {
+		t.Errorf("Len() = %d, want %d", pack.Len(), len(files))
+	}
+
+	size := pack.Size()
+	data, md, err := pack.Seal(aes.ProxyReader)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+
+	// the metadata is read back from storage with prefix
+	md = stored(md)
+	offset, length := md.PackIndex()
+	if md.Kind() != KindPack || offset != size || offset+length != data.Size() || int64(md.FileSize()) != data.Size() {
+		t.Errorf("Seal() metadata = %v, data %d bytes", md, data.Size())
+	}
+
+	content, _ := ioutil.ReadAll(data)
+	u := &memUploader{objects: map[string][]byte{pack.Key(): content}}
+	item := &Item{ObjectKey: pack.Key(), Metadata: md}
+
+	items, err := ReadPackIndex(context.Background(), u, item, aes.ProxyWriter)
+	if err != nil {
+		t.Fatalf("ReadPackIndex() error = %v", err)
+	}
+	if len(items) != len(files) {
+		t.Fatalf("ReadPackIndex() = %d items, want %d", len(items), len(files))
+	}
+
+	// the index is read by range
+	if ref := u.downloads[0].Pack; ref == nil || ref.Offset != offset || ref.Length != length {
+		t.Errorf("index downloaded by %+v", ref)
+	}
+
+	for i, f := range files {
+		got := items[i]
+		if got.ObjectKey != f.key || got.Filename != f.filename || got.FileSize != int64(len(f.content)) || got.Pack.Object != pack.Key() {
+			t.Errorf("item %d = %+v, pack %+v", i, got, got.Pack)
+		}
+		if got.ModTime != time.Unix(int64(1600000000+i), 0) || got.Metadata.ModTime() != int64(1600000000+i) {
+			t.Errorf("item %d mtime = %s, %d", i, got.ModTime, got.Metadata.ModTime())
+		}
+		if got.Metadata.Checksum() != "sum-"+f.key || got.Metadata.FileSize() != len(f.content) {
+			t.Errorf("item %d metadata = %v", i, got.Metadata)
+		}
+
+		// the file is read from the pack by its range
+		buf := bytes.Buffer{}
+		w := aes.ProxyWriter(&buf)
+		if err := u.Download(context.Background(), got, w); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != f.content {
+			t.Errorf("item %d content = %q, want %q", i, buf.String(), f.content)
+		}
+	}
+}
+
+func TestReadPackIndexErrors(t *testing.T) {
+	aes, err := crypto.NewAes(&crypto.AesConfig{Password: "pw"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pack := NewPack()
+	pack.Add(&PackEntry{Key: "a", Filename: "a"}, encrypt(t, aes, "a"))
+	data, md, err := pack.Seal(aes.ProxyReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(data)
+
+	other, err := crypto.NewAes(&crypto.AesConfig{Password: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		objects map[string][]byte
+		md      Metadata
+		wrap    func(io.Writer) io.Writer
+	}{
+		{"no index", map[string][]byte{pack.Key(): content}, stored(Metadata{"Kind": KindPack}), aes.ProxyWriter},
+		{"missing object", map[string][]byte{}, stored(md), aes.ProxyWriter},
+		{"wrong password", map[string][]byte{pack.Key(): content}, stored(md), other.ProxyWriter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &memUploader{objects: tt.objects}
+			if _, err := ReadPackIndex(context.Background(), u, &Item{ObjectKey: pack.Key(), Metadata: tt.md}, tt.wrap); err == nil {
+				t.Error("ReadPackIndex() should fail")
+			}
+		})
+	}
+}
+
+func TestPackKeySorted(t *testing.T) {
+	older := NewPack()
+	time.Sleep(time.Millisecond)
+	newer := NewPack()
+
+	if older.Key() >= newer.Key() {
+		t.Errorf("Key() = %s, %s, want sorted by creation", older.Key(), newer.Key())
+	}
+}
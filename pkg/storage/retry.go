@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/logger"
+	"oss-backup/pkg/progress"
+	"oss-backup/pkg/utils"
+	"syscall"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+// RetryPolicy retries the transient errors of storage with exponential backoff
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   5,
+	Backoff:    time.Second,
+	MaxBackoff: 30 * time.Second,
+	Jitter:     0.2,
+}
+
+// NewRetryPolicy overrides the default policy by the configured fields
+func NewRetryPolicy(cfg *conf.Retry) (*RetryPolicy, error) {
+	policy := DefaultRetryPolicy
+	if cfg == nil {
+		return &policy, nil
+	}
+
+	var err error
+	if cfg.Attempts != 0 {
+		policy.Attempts = cfg.Attempts
+	}
+	if cfg.Backoff != "" {
+		if policy.Backoff, err = utils.ParseDuration(cfg.Backoff); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.MaxBackoff != "" {
+		if policy.MaxBackoff, err = utils.ParseDuration(cfg.MaxBackoff); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Jitter != nil {
+		policy.Jitter = *cfg.Jitter
+	}
+
+	if policy.Attempts < 1 || policy.Backoff < 0 || policy.MaxBackoff < policy.Backoff {
+		return nil, fmt.Errorf("invalid retry policy %+v", policy)
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return nil, fmt.Errorf("retry jitter %v out of range [0, 1]", policy.Jitter)
+	}
+	return &policy, nil
+}
+
+// Do runs the fn of op on key until it succeeded, failed by a permanent
+// error or all of the attempts are used, the last error is returned.
+func (p *RetryPolicy) Do(ctx context.Context, op, key string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.Attempts || !IsRetryable(err) {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		logger.Warn("storage operation failed, retrying", "op", op, "key", key, "attempt", attempt, "attempts", p.Attempts, "delay", delay, "error", err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	d := p.MaxBackoff
+	if attempt < 32 && p.Backoff<<(attempt-1) < p.MaxBackoff {
+		d = p.Backoff << (attempt - 1)
+	}
+
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// permanentError is never retried, e.g. the data consumed cannot be read again
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// IsRetryable reports whether the error is transient: 5xx and throttled
+// responses, timeouts, connection resets and corrupted transfers.
+func IsRetryable(err error) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var serviceErr oss.ServiceError
+	if errors.As(err, &serviceErr) {
+		return serviceErr.StatusCode >= 500 || serviceErr.StatusCode == 429
+	}
+
+	var statusErr oss.UnexpectedStatusCodeError
+	if errors.As(err, &statusErr) {
+		return statusErr.Got() >= 500 || statusErr.Got() == 429
+	}
+
+	var s3Err *S3Error
+	if errors.As(err, &s3Err) {
+		return s3Err.StatusCode >= 500 || s3Err.StatusCode == 429
+	}
+
+	var crcErr oss.CRCCheckError
+	if errors.As(err, &crcErr) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	for _, target := range []error{syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE, io.ErrUnexpectedEOF} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rewinder is implemented by the data of transfer which can be restarted
+type Rewinder interface {
+	Rewind() error
+}
+
+// rewind prepares the data for retrying the failed transfer, the error is
+// made permanent if the data consumed cannot be restarted.
+func rewind(err error, data interface{}, transferred int64) error {
+	if err == nil || transferred == 0 {
+		return err
+	}
+
+	if r, ok := data.(Rewinder); ok {
+		if rerr := r.Rewind(); rerr == nil {
+			return err
+		}
+	}
+	return &permanentError{err: err}
+}
+
+type rewindReader struct {
+	io.Reader
+	file    io.ReadSeeker
+	wrap    func(io.Reader) io.Reader
+	counter *progressReader
+}
+
+// RewindReader reads the file through the wrap, which is read from the
+// beginning again on retry. The progress is counted by the bytes read from
+// the file, e.g. the plaintext before encrypted.
+func RewindReader(file io.ReadSeeker, wrap func(io.Reader) io.Reader) io.Reader {
+	r := &rewindReader{file: file, wrap: wrap, counter: &progressReader{Reader: file}}
+	r.Reader = wrap(r.counter)
+	return r
+}
+
+func (r *rewindReader) Rewind() error {
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r.counter = &progressReader{Reader: r.file, transfer: r.counter.transfer}
+	r.counter.transfer.Update(0)
+	r.Reader = r.wrap(r.counter)
+	return nil
+}
+
+func (r *rewindReader) track(transfer *progress.Transfer) {
+	r.counter.transfer = transfer
+}
+
+type rewindWriter struct {
+	io.Writer
+	file    *os.File
+	wrap    func(io.Writer) io.Writer
+	counter *progressWriter
+}
+
+// RewindWriter writes the file through the wrap, which is truncated and
+// written from the beginning again on retry. The progress is counted by the
+// bytes written to the file, e.g. the plaintext after decrypted.
+func RewindWriter(file *os.File, wrap func(io.Writer) io.Writer) io.Writer {
+	w := &rewindWriter{file: file, wrap: wrap, counter: &progressWriter{Writer: file}}
+	w.Writer = wrap(w.counter)
+	return w
+}
+
+func (w *rewindWriter) Rewind() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	w.counter = &progressWriter{Writer: w.file, transfer: w.counter.transfer}
+	w.counter.transfer.Update(0)
+	w.Writer = w.wrap(w.counter)
+	return nil
+}
+
+func (w *rewindWriter) track(transfer *progress.Transfer) {
+	w.counter.transfer = transfer
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"internal error", oss.ServiceError{StatusCode: http.StatusInternalServerError}, true},
+		{"service unavailable", oss.ServiceError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"throttled", oss.ServiceError{StatusCode: http.StatusTooManyRequests}, true},
+		{"access denied", oss.ServiceError{StatusCode: http.StatusForbidden}, false},
+		{"not found", oss.ServiceError{StatusCode: http.StatusNotFound}, false},
+		{"unexpected 502", oss.CheckRespCode(http.StatusBadGateway, []int{http.StatusOK}), true},
+		{"unexpected 400", oss.CheckRespCode(http.StatusBadRequest, []int{http.StatusOK}), false},
+		{"crc mismatch", oss.CRCCheckError{}, true},
+		{"s3 internal error", &S3Error{StatusCode: http.StatusInternalServerError}, true},
+		{"s3 throttled", fmt.Errorf("upload a: %w", &S3Error{StatusCode: http.StatusTooManyRequests}), true},
+		{"s3 access denied", &S3Error{StatusCode: http.StatusForbidden, Code: "AccessDenied"}, false},
+		{"timeout", &net.OpError{Op: "read", Err: timeoutError{}}, true},
+		{"connection reset", &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"broken pipe", &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, true},
+		{"unexpected eof", fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), true},
+		{"eof", fmt.Errorf("read index: %w", io.EOF), false},
+		{"wrapped service error", fmt.Errorf("upload a: %w", oss.ServiceError{StatusCode: http.StatusBadGateway}), true},
+		{"permanent", &permanentError{err: io.ErrUnexpectedEOF}, false},
+		{"canceled", fmt.Errorf("upload a: %w", context.Canceled), false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"not exist", os.ErrNotExist, false},
+		{"other", errors.New("invalid argument"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := &RetryPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := p.delay(attempt + 1); got != want {
+			t.Errorf("delay(%d) = %s, want %s", attempt+1, got, want)
+		}
+	}
+
+	// large attempts don't overflow
+	if got := p.delay(100); got != p.MaxBackoff {
+		t.Errorf("delay(100) = %s, want %s", got, p.MaxBackoff)
+	}
+
+	p.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := p.delay(1); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("delay(1) with jitter = %s", got)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	p := &RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	retryable := oss.ServiceError{StatusCode: http.StatusServiceUnavailable}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", []error{nil}, 1, false},
+		{"recovered", []error{retryable, nil}, 2, false},
+		{"attempts used", []error{retryable, retryable, retryable, nil}, 3, true},
+		{"permanent", []error{os.ErrPermission, nil}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := p.Do(context.Background(), "test", "key", func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if calls != tt.wantCalls || (err != nil) != tt.wantErr {
+				t.Errorf("Do() called %d times, error = %v, want %d times, error %v", calls, err, tt.wantCalls, tt.wantErr)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	_ = (&RetryPolicy{Attempts: 3, Backoff: time.Hour, MaxBackoff: time.Hour}).Do(ctx, "test", "key", func() error {
+		calls++
+		return retryable
+	})
+	if calls != 1 {
+		t.Errorf("Do() called %d times after canceled, want 1", calls)
+	}
+}
@@ -0,0 +1,454 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"oss-backup/pkg/conf"
+	"oss-backup/pkg/credentials"
+	"oss-backup/pkg/progress"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3MetaPrefix    = "X-Amz-Meta-"
+	s3DefaultRegion = "us-east-1"
+	// emptySha256 is the hash of empty payload
+	emptySha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Error is the error returned by the services compatible with S3
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	RequestId  string `xml:"RequestId"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: service returned error: StatusCode=%d, Code=%s, Message=%q, RequestId=%s",
+		e.StatusCode, e.Code, e.Message, e.RequestId)
+}
+
+// S3 is the storage of services compatible with S3, e.g. MinIO. The bucket
+// is addressed by path and the requests are signed by signature version 4.
+type S3 struct {
+	cfg      *conf.Bucket
+	endpoint *url.URL
+	region   string
+	provider credentials.Provider
+	client   *http.Client
+	retry    *RetryPolicy
+}
+
+func (s *S3) Exists(ctx context.Context, key string) (bool, error) {
+	var ok bool
+	err := s.retry.Do(ctx, "exists", key, func() error {
+		_, err := s.head(ctx, s.genObjectKey(key))
+		ok = err == nil
+
+		var s3Err *S3Error
+		if errors.As(err, &s3Err) && s3Err.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	})
+
+	return ok, err
+}
+
+func (s *S3) Metadata(ctx context.Context, key string) (Metadata, error) {
+	return s.metadata(ctx, s.genObjectKey(key))
+}
+
+// metadata gets the metadata by the full key of object, the user metadata
+// is renamed with the prefix of oss
+func (s *S3) metadata(ctx context.Context, key string) (Metadata, error) {
+	md := make(Metadata)
+	err := s.retry.Do(ctx, "metadata", key, func() error {
+		header, err := s.head(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		for k, vs := range header {
+			if len(vs) == 0 {
+				continue
+			}
+			if strings.HasPrefix(k, s3MetaPrefix) {
+				k = propPrefix + strings.TrimPrefix(k, s3MetaPrefix)
+			}
+			md[k] = vs[0]
+		}
+		return nil
+	})
+
+	return md, err
+}
+
+func (s *S3) head(ctx context.Context, key string) (http.Header, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil, 0, emptySha256)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	return resp.Header, nil
+}
+
+// SetMetadata replaces the metadata by copying the object to itself
+func (s *S3) SetMetadata(ctx context.Context, key string, metadata Metadata) error {
+	key = s.genObjectKey(key)
+	header := s.metaHeader(metadata)
+	header.Set("X-Amz-Copy-Source", "/"+s.cfg.BucketName+"/"+uriEncode(key, false))
+	header.Set("X-Amz-Metadata-Directive", "REPLACE")
+
+	return s.retry.Do(ctx, "set_metadata", key, func() error {
+		resp, err := s.do(ctx, http.MethodPut, key, nil, header, nil, 0, emptySha256)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		// the copy may fail after the status is sent
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(body, []byte("<Error>")) {
+			return parseS3Error(http.StatusInternalServerError, body)
+		}
+		return nil
+	})
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// ListObject lists the objects under the prefix, the error of listing is
+// returned by wait after the channel closed.
+func (s *S3) ListObject(ctx context.Context, prefix string) (chan *Item, func() error) {
+	return listItems(prefix, func(marker string) ([]string, string, error) {
+		query := url.Values{"list-type": {"2"}, "max-keys": {"1000"}}
+		if prefix != "" {
+			query.Set("prefix", prefix)
+		}
+		if marker != "" {
+			query.Set("continuation-token", marker)
+		}
+
+		var res listBucketResult
+		if err := s.retry.Do(ctx, "list", prefix, func() error {
+			resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil, 0, emptySha256)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			res = listBucketResult{}
+			return xml.NewDecoder(resp.Body).Decode(&res)
+		}); err != nil {
+			return nil, "", err
+		}
+
+		keys := make([]string, 0, len(res.Contents))
+		for _, obj := range res.Contents {
+			keys = append(keys, obj.Key)
+		}
+
+		next := ""
+		if res.IsTruncated {
+			next = res.NextContinuationToken
+		}
+		return keys, next, nil
+	}, func(key string) (Metadata, error) {
+		return s.metadata(ctx, key)
+	})
+}
+
+// Upload puts the object. The length and hash of content are signed, so the
+// data is written to a temporary file before it's sent.
+func (s *S3) Upload(ctx context.Context, item *Item, data io.Reader, metadata Metadata) error {
+	transfer := progress.FromContext(ctx).Start(item.Filename, item.FileSize)
+	defer transfer.Done()
+
+	fp, err := ioutil.TempFile("", "oss-backup-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = fp.Close()
+		_ = os.Remove(fp.Name())
+	}()
+
+	// the progress is counted before encrypted, as the size of item
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(fp, hash), &contextReader{ctx: ctx, source: trackReader(data, transfer)})
+	if err != nil {
+		return err
+	}
+	payloadHash := hex.EncodeToString(hash.Sum(nil))
+
+	header := s.metaHeader(metadata)
+	header.Set("Content-Type", "application/octet-stream")
+	key := s.genObjectKey(item.ObjectKey)
+	return s.retry.Do(ctx, "upload", item.ObjectKey, func() error {
+		body := &contextReader{ctx: ctx, source: io.NewSectionReader(fp, 0, size)}
+		resp, err := s.do(ctx, http.MethodPut, key, nil, header, body, size, payloadHash)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	})
+}
+
+// Download gets the object, or the range of pack if the item is packed. The
+// writer is restarted on retry if it's a Rewinder.
+func (s *S3) Download(ctx context.Context, item *Item, w io.Writer) error {
+	transfer := progress.FromContext(ctx).Start(item.Filename, item.FileSize)
+	defer transfer.Done()
+
+	key := item.ObjectKey
+	header := http.Header{}
+	if item.Pack != nil {
+		key = item.Pack.Object
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", item.Pack.Offset, item.Pack.Offset+item.Pack.Length-1))
+	}
+
+	// the progress is counted after decrypted, as the size of item
+	tracked := trackWriter(w, transfer)
+	return s.retry.Do(ctx, "download", key, func() error {
+		resp, err := s.do(ctx, http.MethodGet, key, nil, header, nil, 0, emptySha256)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		cw := &contextWriter{ctx: ctx, source: tracked}
+		_, err = io.Copy(cw, resp.Body)
+		return rewind(err, w, cw.n)
+	})
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.retry.Do(ctx, "delete", key, func() error {
+		resp, err := s.do(ctx, http.MethodDelete, s.genObjectKey(key), nil, nil, nil, 0, emptySha256)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	})
+}
+
+func (s *S3) genObjectKey(key string) string {
+	return ObjectKey(s.cfg.ObjectPrefix, key)
+}
+
+func (s *S3) metaHeader(metadata Metadata) http.Header {
+	header := http.Header{}
+	for k, v := range metadata {
+		header.Set(s3MetaPrefix+k, v)
+	}
+	return header
+}
+
+// do sends the signed request of object, or of bucket if the key is empty.
+// The error response is returned as *S3Error.
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.BucketName
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = query.Encode()
+
+	// the empty body is sent with the length rather than chunked
+	if size == 0 {
+		body = nil
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.ContentLength = size
+
+	creds, err := s.provider.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signV4(req, creds, s.region, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		defer func() { _ = resp.Body.Close() }()
+
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, parseS3Error(resp.StatusCode, data)
+	}
+	return resp, nil
+}
+
+func parseS3Error(statusCode int, body []byte) error {
+	s3Err := &S3Error{}
+	_ = xml.Unmarshal(body, s3Err)
+	s3Err.StatusCode = statusCode
+	return s3Err
+}
+
+// signV4 signs the request by AWS signature version 4, the payload hash is
+// taken from the header X-Amz-Content-Sha256.
+func signV4(req *http.Request, creds *credentials.Credentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SecurityToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SecurityToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, vs := range req.Header {
+		name := strings.ToLower(k)
+		switch {
+		case strings.HasPrefix(name, "x-amz-"), name == "content-type", name == "content-md5", name == "range":
+			values := make([]string, len(vs))
+			for i, v := range vs {
+				values[i] = strings.Join(strings.Fields(v), " ")
+			}
+			headers[name] = strings.Join(values, ",")
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.AccessKeySecret)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSha256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyId+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := make([]string, 0, len(query))
+	for k, vs := range query {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode escapes the string as required by signature version 4, the slash
+// is kept unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	buf := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func NewS3(cfg *conf.Bucket) (*S3, error) {
+	retry, err := NewRetryPolicy(cfg.Retry)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := credentials.ForBucket(cfg)
+	if _, err := provider.Retrieve(); err != nil {
+		return nil, err
+	}
+
+	endpoint := cfg.Endpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = s3DefaultRegion
+	}
+
+	return &S3{
+		cfg:      cfg,
+		endpoint: u,
+		region:   region,
+		provider: provider,
+		client:   &http.Client{},
+		retry:    retry,
+	}, nil
+}
@@ -2,8 +2,13 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"oss-backup/pkg/conf"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Metadata map[string]string
@@ -13,8 +18,48 @@ const (
 	metadataModifyTimestamp = "Modify-Time"
 	metadataFilename        = "Filename"
 	metadataFileSize        = "File-Size"
+	metadataModifyTimeNs    = "Modify-Time-Ns"
+	metadataChangeTimeNs    = "Change-Time-Ns"
+	metadataInode           = "Inode"
+	metadataChecksum        = "Checksum"
+	metadataHost            = "Host"
+	metadataKind            = "Kind"
+	metadataIndexOffset     = "Index-Offset"
+	metadataIndexLength     = "Index-Length"
 )
 
+// Get returns the value of user metadata by name, e.g. Get("Kind")
+func (md Metadata) Get(name string) string {
+	return md[propPrefix+name]
+}
+
+// Set sets the value of user metadata which will be uploaded
+func (md Metadata) Set(name, value string) {
+	md[name] = value
+}
+
+// User returns the user metadata without the prefix of headers, which can
+// be uploaded again
+func (md Metadata) User() Metadata {
+	user := make(Metadata)
+	for k, v := range md {
+		if strings.HasPrefix(k, propPrefix) {
+			user[strings.TrimPrefix(k, propPrefix)] = v
+		}
+	}
+	return user
+}
+
+// ReplaceEncrypted replaces the encrypted values of user metadata, e.g. to
+// encrypt them by another password
+func (md Metadata) ReplaceEncrypted(replace func(string) string) {
+	for _, name := range []string{metadataFilename, metadataChecksum, metadataHost} {
+		if v, ok := md[name]; ok {
+			md[name] = replace(v)
+		}
+	}
+}
+
 func (md Metadata) ModTime() int64 {
 	if ts, ok := md[propPrefix+metadataModifyTimestamp]; ok {
 		if n, err := strconv.ParseInt(ts, 10, 64); err == nil {
@@ -43,6 +88,42 @@ func (md Metadata) FileSize() int {
 	return 0
 }
 
+// ModTimeNs returns the mtime in nanoseconds, 0 if it's not recorded
+func (md Metadata) ModTimeNs() int64 {
+	n, _ := strconv.ParseInt(md[propPrefix+metadataModifyTimeNs], 10, 64)
+	return n
+}
+
+// ChangeTime returns the ctime in nanoseconds and inode of file, both are 0
+// if they are not recorded
+func (md Metadata) ChangeTime() (ctime int64, inode uint64) {
+	ctime, _ = strconv.ParseInt(md[propPrefix+metadataChangeTimeNs], 10, 64)
+	inode, _ = strconv.ParseUint(md[propPrefix+metadataInode], 10, 64)
+	return
+}
+
+// Checksum returns the encrypted sha256 of content
+func (md Metadata) Checksum() string {
+	return md[propPrefix+metadataChecksum]
+}
+
+// Host returns the encrypted host which the file is backed up from
+func (md Metadata) Host() string {
+	return md[propPrefix+metadataHost]
+}
+
+// Kind returns the kind of object, empty for the object of a single file
+func (md Metadata) Kind() string {
+	return md[propPrefix+metadataKind]
+}
+
+// PackIndex returns the range of encrypted index in the pack object
+func (md Metadata) PackIndex() (offset, length int64) {
+	offset, _ = strconv.ParseInt(md[propPrefix+metadataIndexOffset], 10, 64)
+	length, _ = strconv.ParseInt(md[propPrefix+metadataIndexLength], 10, 64)
+	return
+}
+
 func (md Metadata) SetModTime(ts int64) {
 	md[metadataModifyTimestamp] = strconv.Itoa(int(ts))
 }
@@ -55,17 +136,133 @@ func (md Metadata) SetFileSize(size int) {
 	md[metadataFileSize] = strconv.Itoa(size)
 }
 
+func (md Metadata) SetModTimeNs(ns int64) {
+	md[metadataModifyTimeNs] = strconv.FormatInt(ns, 10)
+}
+
+func (md Metadata) SetChangeTime(ctime int64, inode uint64) {
+	md[metadataChangeTimeNs] = strconv.FormatInt(ctime, 10)
+	md[metadataInode] = strconv.FormatUint(inode, 10)
+}
+
+func (md Metadata) SetChecksum(checksum string) {
+	md[metadataChecksum] = checksum
+}
+
+func (md Metadata) SetHost(host string) {
+	md[metadataHost] = host
+}
+
+func (md Metadata) SetKind(kind string) {
+	md[metadataKind] = kind
+}
+
+func (md Metadata) SetPackIndex(offset, length int64) {
+	md[metadataIndexOffset] = strconv.FormatInt(offset, 10)
+	md[metadataIndexLength] = strconv.FormatInt(length, 10)
+}
+
 type Item struct {
-	Filename  string   `json:"filename"`
-	ObjectKey string   `json:"object_key"`
-	FileSize  int64    `json:"file_size"`
-	Metadata  Metadata `json:"metadata"`
+	Filename  string    `json:"filename"`
+	Host      string    `json:"host,omitempty"`
+	ObjectKey string    `json:"object_key"`
+	FileSize  int64     `json:"file_size"`
+	ModTime   time.Time `json:"mod_time"`
+	Metadata  Metadata  `json:"metadata"`
+	Pack      *PackRef  `json:"pack,omitempty"`
 }
 
 type Uploader interface {
-	Exists(key string) bool
-	Metadata(key string) Metadata
-	ListObject(prefix string) chan *Item
+	Exists(ctx context.Context, key string) (bool, error)
+	Metadata(ctx context.Context, key string) (Metadata, error)
+	ListObject(ctx context.Context, prefix string) (items chan *Item, wait func() error)
+	SetMetadata(ctx context.Context, key string, metadata Metadata) error
 	Upload(ctx context.Context, item *Item, reader io.Reader, metadata Metadata) error
 	Download(ctx context.Context, item *Item, w io.Writer) error
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the uploader of bucket by its type
+func New(cfg *conf.Bucket) (Uploader, error) {
+	switch cfg.Type {
+	case "", conf.BucketTypeOss:
+		ao, err := NewAliYunOSS(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return ao, nil
+	case conf.BucketTypeS3:
+		s3, err := NewS3(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	}
+
+	return nil, fmt.Errorf("unknown type %q of bucket %s", cfg.Type, cfg.Alias)
+}
+
+// listItems lists the keys page by page and gets the metadata of each key,
+// the error of listing is returned by wait after the channel closed.
+func listItems(prefix string, page func(marker string) (keys []string, next string, err error), metadata func(key string) (Metadata, error)) (chan *Item, func() error) {
+	ch := make(chan *Item, 1024)
+	wg := sync.WaitGroup{}
+
+	var mu sync.Mutex
+	var listErr error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if listErr == nil {
+			listErr = err
+		}
+	}
+
+	go func() {
+		marker := ""
+		for {
+			keys, next, err := page(marker)
+			if err != nil {
+				fail(fmt.Errorf("list objects of %q: %w", prefix, err))
+				break
+			}
+
+			for _, key := range keys {
+				wg.Add(1)
+				go func(key string) {
+					defer wg.Done()
+
+					// the listed keys contain the prefix already
+					md, err := metadata(key)
+					if err != nil {
+						fail(fmt.Errorf("metadata of %s: %w", key, err))
+						return
+					}
+
+					ch <- &Item{
+						ObjectKey: key,
+						Metadata:  md,
+						FileSize:  int64(md.FileSize()),
+						ModTime:   time.Unix(md.ModTime(), 0),
+					}
+				}(key)
+			}
+
+			if next == "" {
+				break
+			}
+			marker = next
+		}
+
+		wg.Wait()
+		close(ch)
+	}()
+
+	return ch, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return listErr
+	}
 }
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var byteUnits = []struct {
+	suffix string
+	n      int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"t", 1 << 40},
+	{"b", 1},
+}
+
+// ParseBytes parses the human readable size like 512K, 10MiB or 1.5GB, the
+// units with i (and the single letter ones) are powers of 1024.
+func ParseBytes(s string) (int64, error) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(v, unit.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(v, unit.suffix)), 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return int64(n * float64(unit.n)), nil
+		}
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n, nil
+}
+
+// ParseRate parses the bytes per second like 10MiB/s or 10MBps, the rate
+// in bits like 100Mbps (lower case b) is divided by 8. An empty string means
+// unlimited and returns 0.
+func ParseRate(s string) (int64, error) {
+	v := strings.TrimSpace(s)
+	if v == "" {
+		return 0, nil
+	}
+
+	for _, per := range []string{"/s", "ps"} {
+		if strings.HasSuffix(strings.ToLower(v), per) {
+			v = v[:len(v)-len(per)]
+			break
+		}
+	}
+
+	n, err := ParseBytes(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	if strings.HasSuffix(v, "b") {
+		n /= 8
+	}
+	return n, nil
+}
+
+// FormatBytes formats the size in human readable units of 1024
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 4; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f%ciB", float64(n)/float64(div), "KMGTP"[exp])
+}
@@ -0,0 +1,92 @@
+package utils
+
+import "testing"
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "1024", want: 1024},
+		{in: "512K", want: 512 << 10},
+		{in: "512k", want: 512 << 10},
+		{in: "10MiB", want: 10 << 20},
+		{in: "10mib", want: 10 << 20},
+		{in: "10 MiB", want: 10 << 20},
+		{in: "1.5GB", want: 1.5e9},
+		{in: "2TiB", want: 2 << 40},
+		{in: "64B", want: 64},
+		{in: " 1M ", want: 1 << 20},
+		{in: "", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "-1KiB", wantErr: true},
+		{in: "MiB", wantErr: true},
+		{in: "10XB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseBytes(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBytes(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseBytes(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "", want: 0},
+		{in: "  ", want: 0},
+		{in: "10MiB/s", want: 10 << 20},
+		{in: "10MiB/S", want: 10 << 20},
+		{in: "10MIB/S", want: 10 << 20},
+		{in: "10MBps", want: 10e6},
+		{in: "10MbPS", want: 10e6 / 8},
+		{in: "100Mbps", want: 100e6 / 8},
+		{in: "8Mb/s", want: 1e6},
+		{in: "64kbps", want: 64e3 / 8},
+		{in: "512K", want: 512 << 10},
+		{in: "fast", wantErr: true},
+		{in: "10MiB/min", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRate(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want string
+	}{
+		{in: 0, want: "0B"},
+		{in: 1023, want: "1023B"},
+		{in: 1024, want: "1.00KiB"},
+		{in: 1536, want: "1.50KiB"},
+		{in: 10 << 20, want: "10.00MiB"},
+		{in: 3 << 40, want: "3.00TiB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatBytes(tt.in); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// NormalizePath converts backslashes to slashes and trims the leading slash,
+// so that the paths from different platforms can be matched by same pattern.
+func NormalizePath(filename string) string {
+	return strings.TrimLeft(strings.ReplaceAll(filename, "\\", "/"), "/")
+}
+
+// CompileGlob compiles the glob pattern into regexp, ** matches any number
+// of directories, * and ? match any characters except the separator.
+func CompileGlob(pattern string) (*regexp.Regexp, error) {
+	pattern = NormalizePath(pattern)
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// **/ matches zero or more directories
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			if j := strings.IndexByte(pattern[i:], ']'); j > 0 {
+				class := pattern[i+1 : i+j]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				sb.WriteString("[" + class + "]")
+				i += j
+			} else {
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+
+	return regexp.Compile(sb.String())
+}
@@ -0,0 +1,97 @@
+package utils
+
+import "testing"
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "/home/alice/a.txt", want: "home/alice/a.txt"},
+		{in: `C:\Users\alice\a.txt`, want: "C:/Users/alice/a.txt"},
+		{in: `\\server\share`, want: "server/share"},
+		{in: "relative/a", want: "relative/a"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizePath(tt.in); got != tt.want {
+			t.Errorf("NormalizePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		match    []string
+		notMatch []string
+	}{
+		{
+			pattern:  "home/alice/docs/**",
+			match:    []string{"home/alice/docs/a.txt", "home/alice/docs/x/y/z.txt"},
+			notMatch: []string{"home/alice/doc.txt", "home/bob/docs/a.txt"},
+		},
+		{
+			pattern:  "/home/*/a.txt",
+			match:    []string{"home/alice/a.txt", "home/bob/a.txt"},
+			notMatch: []string{"home/alice/x/a.txt", "home/a.txt"},
+		},
+		{
+			pattern:  "**/*.conf",
+			match:    []string{"nginx.conf", "etc/nginx/nginx.conf"},
+			notMatch: []string{"etc/nginx/nginx.conf.bak"},
+		},
+		{
+			pattern:  "etc/**/hosts",
+			match:    []string{"etc/hosts", "etc/a/b/hosts"},
+			notMatch: []string{"etc/hosts.allow"},
+		},
+		{
+			pattern:  "log/app-?.log",
+			match:    []string{"log/app-1.log"},
+			notMatch: []string{"log/app-10.log", "log/app-/.log"},
+		},
+		{
+			pattern:  "log/app-[0-9].log",
+			match:    []string{"log/app-7.log"},
+			notMatch: []string{"log/app-x.log"},
+		},
+		{
+			pattern:  "log/app-[!0-9].log",
+			match:    []string{"log/app-x.log"},
+			notMatch: []string{"log/app-7.log"},
+		},
+		{
+			pattern:  "a+b(1).txt",
+			match:    []string{"a+b(1).txt"},
+			notMatch: []string{"aab1.txt"},
+		},
+		{
+			pattern:  `C:\Users\*\a.txt`,
+			match:    []string{"C:/Users/alice/a.txt"},
+			notMatch: []string{"C:/Users/alice/b.txt"},
+		},
+		{
+			pattern: "unclosed[",
+			match:   []string{"unclosed["},
+		},
+	}
+
+	for _, tt := range tests {
+		re, err := CompileGlob(tt.pattern)
+		if err != nil {
+			t.Errorf("CompileGlob(%q) error = %v", tt.pattern, err)
+			continue
+		}
+
+		for _, s := range tt.match {
+			if !re.MatchString(s) {
+				t.Errorf("CompileGlob(%q) does not match %q", tt.pattern, s)
+			}
+		}
+		for _, s := range tt.notMatch {
+			if re.MatchString(s) {
+				t.Errorf("CompileGlob(%q) matches %q", tt.pattern, s)
+			}
+		}
+	}
+}
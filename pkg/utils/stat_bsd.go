@@ -0,0 +1,18 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// ChangeTime returns the ctime in nanoseconds and inode of file, both are
+// zero if they are not supported by the platform.
+func ChangeTime(info os.FileInfo) (ctime int64, inode uint64) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return st.Ctimespec.Nano(), uint64(st.Ino)
+	}
+	return 0, 0
+}
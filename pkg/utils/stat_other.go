@@ -0,0 +1,12 @@
+//go:build !linux && !openbsd && !darwin && !freebsd && !netbsd
+// +build !linux,!openbsd,!darwin,!freebsd,!netbsd
+
+package utils
+
+import "os"
+
+// ChangeTime returns the ctime in nanoseconds and inode of file, both are
+// zero if they are not supported by the platform.
+func ChangeTime(info os.FileInfo) (ctime int64, inode uint64) {
+	return 0, 0
+}
@@ -0,0 +1,13 @@
+package utils
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func IsTerminal(fp *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fp.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
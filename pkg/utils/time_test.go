@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "90m", want: 90 * time.Minute},
+		{in: "7d", want: 7 * 24 * time.Hour},
+		{in: "1.5d", want: 36 * time.Hour},
+		{in: "2w", want: 14 * 24 * time.Hour},
+		{in: "xd", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDuration(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseDuration(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseTimeSpec(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "2021-01-02", want: time.Date(2021, 1, 2, 0, 0, 0, 0, time.Local)},
+		{in: "2021-01-02 15:04:05", want: time.Date(2021, 1, 2, 15, 4, 5, 0, time.Local)},
+		{in: "2021-01-02T15:04:05", want: time.Date(2021, 1, 2, 15, 4, 5, 0, time.Local)},
+		{in: "2021-01-02T15:04:05Z", want: time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{in: "7d", want: now.Add(-7 * 24 * time.Hour)},
+		{in: "36h", want: now.Add(-36 * time.Hour)},
+		{in: "2021-13-01", wantErr: true},
+		{in: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTimeSpec(tt.in, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseTimeSpec(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseTimeSpec(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}